@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/zhirsch/destiny2-api/models"
+	db "github.com/zhirsch/destiny2-db"
+)
+
+// difficultyTiers are the names of the activity difficulty tiers, indexed by
+// the tier in the activity definition.
+var difficultyTiers = []string{
+	"Trivial",
+	"Easy",
+	"Normal",
+	"Challenging",
+	"Hard",
+	"Brave",
+	"Almost Impossible",
+	"Impossible",
+}
+
+// getActivityDefinition returns the definition of the activity.
+func getActivityDefinition(manifest *db.DB, activityHash int64) (*models.DestinyDefinitionsDestinyActivityDefinition, error) {
+	activityDefinitionInterface, err := getDefinition(manifest, "DestinyActivityDefinition", uint32(activityHash), &models.DestinyDefinitionsDestinyActivityDefinition{})
+	if err != nil {
+		return nil, err
+	}
+	return activityDefinitionInterface.(*models.DestinyDefinitionsDestinyActivityDefinition), nil
+}
+
+// getActivityName returns the name of the activity, or its hash if there's no
+// manifest or the definition can't be looked up or has no name.
+func getActivityName(manifest *db.DB, activityHash int64) string {
+	name := fmt.Sprint(activityHash)
+	if manifest == nil {
+		return name
+	}
+	activityDefinition, err := getActivityDefinition(manifest, activityHash)
+	if err != nil {
+		memberLogger.Printf("unable to get the definition of activity %v: %v", activityHash, err)
+		return name
+	}
+	if activityDefinition.DisplayProperties == nil || activityDefinition.DisplayProperties.Name == "" {
+		return name
+	}
+	return activityDefinition.DisplayProperties.Name
+}
+
+// getDifficultyTier returns the name of the activity's difficulty tier, or ""
+// if the definition doesn't have one.
+func getDifficultyTier(activityDefinition *models.DestinyDefinitionsDestinyActivityDefinition) string {
+	if activityDefinition.Tier <= 0 || int(activityDefinition.Tier) >= len(difficultyTiers) {
+		return ""
+	}
+	return difficultyTiers[activityDefinition.Tier]
+}
+
+// addActivityDetails fills in the name and difficulty tier of each completion
+// in the report. Activities that can't be looked up are left without them.
+func addActivityDetails(manifest *db.DB, report *WeekReport) {
+	var completions []*CompletionReport
+	for _, c := range report.Completions {
+		completions = append(completions, c)
+		if c.Fastest != nil {
+			completions = append(completions, c.Fastest)
+		}
+	}
+	for _, c := range completions {
+		if c.ActivityHash == 0 {
+			continue
+		}
+		activityDefinition, err := getActivityDefinition(manifest, c.ActivityHash)
+		if err != nil {
+			memberLogger.Printf("unable to get the definition of activity %v: %v", c.ActivityHash, err)
+			continue
+		}
+		if activityDefinition.DisplayProperties != nil {
+			c.Activity = activityDefinition.DisplayProperties.Name
+		}
+		c.Tier = getDifficultyTier(activityDefinition)
+	}
+}
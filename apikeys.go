@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	runtime_client "github.com/go-openapi/runtime/client"
+	"github.com/go-openapi/strfmt"
+	"github.com/zhirsch/destiny2-api/client"
+)
+
+// defaultThrottle is how long a throttled API key isn't used when the response
+// doesn't say how long to wait.
+const defaultThrottle = 10 * time.Second
+
+// throttleErrorCodes are the Bungie error codes of a throttled request, which
+// can come with a 200 response rather than a 429.
+var throttleErrorCodes = map[int32]bool{
+	36: true, // ThrottleLimitExceededMomentarily
+	51: true, // PerEndpointRequestThrottleExceeded
+}
+
+// apiKeysFlag is a flag that can be given several times to supply several API
+// keys.
+type apiKeysFlag []string
+
+func (f *apiKeysFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *apiKeysFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// getAPIKeys returns the API keys from --apikey and --apikey-file.
+func getAPIKeys() ([]string, error) {
+	keys := append([]string(nil), flagAPIKeys...)
+	if flagAPIKeyFile == "" {
+		return keys, nil
+	}
+	f, err := os.Open(flagAPIKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// apiKeyRotator is an http.RoundTripper that sends each request with an API
+// key that isn't throttled, and retries a throttled request with the next key.
+type apiKeyRotator struct {
+	next http.RoundTripper
+
+	mu        sync.Mutex
+	keys      []string
+	throttled map[string]time.Time
+	requests  map[string]int
+	current   int
+}
+
+func newAPIKeyRotator(keys []string, next http.RoundTripper) *apiKeyRotator {
+	return &apiKeyRotator{
+		next:      next,
+		keys:      keys,
+		throttled: make(map[string]time.Time),
+		requests:  make(map[string]int),
+	}
+}
+
+// pick returns the key to use, preferring the current key if it isn't
+// throttled, then the next key that isn't, then the key whose throttle ends
+// first.
+func (r *apiKeyRotator) pick() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	soonest := r.current
+	for i := range r.keys {
+		j := (r.current + i) % len(r.keys)
+		until := r.throttled[r.keys[j]]
+		if !now.Before(until) {
+			r.current = j
+			r.requests[r.keys[j]]++
+			return r.keys[j]
+		}
+		if until.Before(r.throttled[r.keys[soonest]]) {
+			soonest = j
+		}
+	}
+	r.current = soonest
+	r.requests[r.keys[soonest]]++
+	return r.keys[soonest]
+}
+
+func (r *apiKeyRotator) throttle(key string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	logger.Printf("API key %v is throttled for %v", redactAPIKey(key), d)
+	r.throttled[key] = time.Now().Add(d)
+}
+
+// isThrottled returns whether the response is a throttled request's, by its
+// status or by its error code and ThrottleSeconds.
+func isThrottled(resp *http.Response, body []byte) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	var v struct {
+		ErrorCode       int32
+		ThrottleSeconds int32
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return false
+	}
+	// A successful response's ThrottleSeconds is only advice.
+	return throttleErrorCodes[v.ErrorCode] || (v.ErrorCode > 1 && v.ThrottleSeconds > 0)
+}
+
+// getThrottle returns how long the throttled response says to wait, from its
+// Retry-After header or its ThrottleSeconds.
+func getThrottle(resp *http.Response, body []byte) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	var v struct {
+		ThrottleSeconds int32
+	}
+	if err := json.Unmarshal(body, &v); err == nil && v.ThrottleSeconds > 0 {
+		return time.Duration(v.ThrottleSeconds) * time.Second
+	}
+	return defaultThrottle
+}
+
+func (r *apiKeyRotator) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		key := r.pick()
+		attemptReq := req.Clone(req.Context())
+		attemptReq.Header.Set("X-API-Key", key)
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+		resp, err := r.next.RoundTrip(attemptReq)
+		if err != nil {
+			return resp, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if !isThrottled(resp, body) {
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			return resp, nil
+		}
+		r.throttle(key, getThrottle(resp, body))
+		// Give up once every key has been tried, or if the request can't be
+		// sent again.
+		if attempt+1 >= len(r.keys) || (req.Body != nil && req.GetBody == nil) {
+			stats.recordThrottle(false)
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			return resp, nil
+		}
+		stats.recordThrottle(true)
+	}
+}
+
+// Write writes the number of requests served by each key.
+func (r *apiKeyRotator) Write(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := append([]string(nil), r.keys...)
+	sort.Slice(keys, func(i, j int) bool {
+		return r.requests[keys[i]] > r.requests[keys[j]]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "api key %-12s %8d requests\n", redactAPIKey(key), r.requests[key])
+	}
+}
+
+// redactAPIKey returns enough of the key to tell keys apart in the logs.
+func redactAPIKey(key string) string {
+	if len(key) <= 4 {
+		return "..."
+	}
+	return "..." + key[len(key)-4:]
+}
+
+// apiKeys rotates between the API keys when there is more than one.
+var apiKeys *apiKeyRotator
+
+// newAPIClient returns the API client. With a single key it's the default
+// client; with several, its requests rotate between the keys.
+func newAPIClient(keys []string) *client.BungieNet {
+	if len(keys) < 2 {
+		return client.Default
+	}
+	if apiKeys == nil {
+		apiKeys = newAPIKeyRotator(keys, http.DefaultTransport)
+	}
+	transport := runtime_client.New(client.DefaultHost, client.DefaultBasePath, client.DefaultSchemes)
+	transport.Transport = apiKeys
+	return client.New(transport, strfmt.Default)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+// userAndClan is a user and their clan.
+type userAndClan struct {
+	user *models.UserUserInfoCard
+	clan *models.GroupsV2GroupV2
+}
+
+// batchTarget is the user and clan being reported for --user-file. While it's
+// set, getUserAndClan returns it instead of using the flags.
+var batchTarget *userAndClan
+
+// resolveUserFile returns the clans of the users in the file, one per line,
+// with each clan only once. A user that can't be resolved is reported and
+// skipped.
+func resolveUserFile(path string) ([]*userAndClan, error) {
+	usernames, err := readMembersFile(path)
+	if err != nil {
+		return nil, err
+	}
+	api, auth, _, err := newAPI()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int64]bool)
+	var targets []*userAndClan
+	for _, username := range usernames {
+		user, err := getDestinyUser(api, auth, username)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: skipping user %q: %v\n", username, err)
+			continue
+		}
+		clan, err := getClan(api, auth, user)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: skipping user %q: %v\n", username, err)
+			continue
+		}
+		if seen[clan.GroupID] {
+			logger.Printf("clan %v (%q) of user %q was already found", clan.GroupID, clan.Name, username)
+			continue
+		}
+		seen[clan.GroupID] = true
+		targets = append(targets, &userAndClan{user, clan})
+	}
+	if len(targets) == 0 {
+		return nil, errors.Errorf("no clans found for the users in %v", path)
+	}
+	return targets, nil
+}
+
+// writeBatchReport writes the report of each clan of the users in the user
+// file. The members skipped in any of the clans are kept in skipped.
+func writeBatchReport(w io.Writer, format string) error {
+	if flagUsername != "" || flagMembershipID != 0 || flagClanName != "" {
+		return errors.Errorf("--user-file can't be used with --user, --membershipid or --clan-name")
+	}
+	targets, err := resolveUserFile(flagUserFile)
+	if err != nil {
+		return err
+	}
+	// The checkpoint is loaded once for all of the clans, since only the
+	// scans saved or resumed since it was loaded are written back, and
+	// loading it for each clan would drop the earlier clans' scans.
+	checkpoints, err = loadCheckpointFile(flagCheckpoint)
+	if err != nil {
+		return err
+	}
+	defer func() { batchTarget = nil }()
+	allSkipped := newSkippedMembers()
+	for _, target := range targets {
+		batchTarget = target
+		if format == "text" {
+			fmt.Fprintf(w, "%v (%v)\n\n", target.clan.Name, target.clan.GroupID)
+		}
+		err := writeReport(w, format)
+		allSkipped.merge(skipped)
+		if err != nil {
+			return errors.Wrapf(err, "clan %v (%q)", target.clan.GroupID, target.clan.Name)
+		}
+	}
+	skipped = allSkipped
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/pkg/errors"
+	"github.com/zhirsch/destiny2-api/client"
+	"github.com/zhirsch/destiny2-api/client/destiny2"
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+// pgcrTTL is how long a post game carnage report is cached. The reports don't
+// change once the activity is over.
+const pgcrTTL = 30 * 24 * time.Hour
+
+// Cache stores values by key for a limited time. Values are stored as JSON, so
+// Get returns a copy of the value that was Set.
+type Cache interface {
+	// Get unmarshals the value for the key into v. It returns false if there
+	// is no value or it has expired.
+	Get(key string, v interface{}) (bool, error)
+	// Set stores v for the key until ttl has passed.
+	Set(key string, v interface{}, ttl time.Duration) error
+}
+
+// cacheEntry is a value stored in a cache.
+type cacheEntry struct {
+	Expires time.Time       `json:"expires"`
+	Value   json.RawMessage `json:"value"`
+}
+
+func newCacheEntry(v interface{}, ttl time.Duration) (*cacheEntry, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheEntry{time.Now().Add(ttl), data}, nil
+}
+
+// get unmarshals the entry's value into v if it hasn't expired.
+func (e *cacheEntry) get(v interface{}) (bool, error) {
+	if time.Now().After(e.Expires) {
+		return false, nil
+	}
+	if err := json.Unmarshal(e.Value, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// memoryCache is a Cache that only lasts for the run.
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: make(map[string]*cacheEntry)}
+}
+
+func (m *memoryCache) Get(key string, v interface{}) (bool, error) {
+	m.mu.Lock()
+	entry, ok := m.entries[key]
+	m.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return entry.get(v)
+}
+
+func (m *memoryCache) Set(key string, v interface{}, ttl time.Duration) error {
+	entry, err := newCacheEntry(v, ttl)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.entries[key] = entry
+	m.mu.Unlock()
+	return nil
+}
+
+// diskCache is a Cache that stores each value in a file in a directory.
+type diskCache struct {
+	dir string
+}
+
+func newDiskCache(dir string) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir}, nil
+}
+
+func (d *diskCache) path(key string) string {
+	return filepath.Join(d.dir, key+".json")
+}
+
+func (d *diskCache) Get(key string, v interface{}) (bool, error) {
+	data, err := ioutil.ReadFile(d.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return false, err
+	}
+	return entry.get(v)
+}
+
+func (d *diskCache) Set(key string, v interface{}, ttl time.Duration) error {
+	entry, err := newCacheEntry(v, ttl)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a concurrent Get never sees a
+	// partially written file.
+	tmp := d.path(key) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path(key))
+}
+
+// cacheMiss returns the error for a missing cache entry with --require-cache.
+func cacheMiss(key string) error {
+	return errors.Errorf("--require-cache: %q isn't cached", key)
+}
+
+// newCache returns a disk cache in the directory, or a memory cache if the
+// directory is empty.
+func newCache(dir string) (Cache, error) {
+	if dir == "" {
+		return newMemoryCache(), nil
+	}
+	return newDiskCache(dir)
+}
+
+// getCachedMembers is like getMembers with cross save resolved, but reuses the
+// cached roster if it is fresh enough. It also returns whether the roster is
+// only part of the clan, which is never cached: a later run would take it for
+// the whole clan.
+func getCachedMembers(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, groupID int64) ([]*ClanMember, bool, error) {
+	key := fmt.Sprintf("roster-%v", groupID)
+	// A roster that doesn't start from the first page, or that can be cut
+	// short by --members-max-pages, is fetched rather than taken from the
+	// cache.
+	limited := flagMembersStartPage > 1 || flagMembersMaxPages < maxMemberPages
+	var members []*ClanMember
+	if flagRequireCache || (!flagRefreshRoster && !limited) {
+		ok, err := cache.Get(key, &members)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			logger.Printf("using cached roster for clan %v (%v members)", groupID, len(members))
+			return members, false, nil
+		}
+		if flagRequireCache {
+			return nil, false, cacheMiss(key)
+		}
+	}
+	members, partial, err := getMembers(api, auth, groupID)
+	if err != nil {
+		return nil, false, err
+	}
+	members, err = resolveCrossSave(api, auth, members)
+	if err != nil {
+		return nil, false, err
+	}
+	if partial {
+		return members, true, nil
+	}
+	if err := cache.Set(key, members, flagRosterTTL); err != nil {
+		return nil, false, err
+	}
+	return members, false, nil
+}
+
+// pgcrCall is a fetch of a post game carnage report that is in progress.
+type pgcrCall struct {
+	done chan struct{}
+	// dups is the number of requests waiting for the fetch.
+	dups int
+	pgcr *models.DestinyHistoricalStatsDestinyPostGameCarnageReportData
+	err  error
+}
+
+// pgcrGroup is the fetches in progress, by instance ID, so that concurrent
+// requests for the same report share a single fetch.
+type pgcrGroup struct {
+	mu sync.Mutex
+	m  map[int64]*pgcrCall
+}
+
+// do returns the result of fetch for the instance, waiting for the fetch that
+// is already in progress for it if there is one.
+func (g *pgcrGroup) do(instanceID int64, fetch func() (*models.DestinyHistoricalStatsDestinyPostGameCarnageReportData, error)) (*models.DestinyHistoricalStatsDestinyPostGameCarnageReportData, error) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[int64]*pgcrCall)
+	}
+	if call, ok := g.m[instanceID]; ok {
+		call.dups++
+		g.mu.Unlock()
+		<-call.done
+		return call.pgcr, call.err
+	}
+	call := &pgcrCall{done: make(chan struct{})}
+	g.m[instanceID] = call
+	g.mu.Unlock()
+
+	call.pgcr, call.err = fetch()
+	g.mu.Lock()
+	delete(g.m, instanceID)
+	g.mu.Unlock()
+	close(call.done)
+	return call.pgcr, call.err
+}
+
+var pgcrCalls pgcrGroup
+
+// getPostGameCarnageReport returns the post game carnage report for the
+// activity instance, using the cached report if there is one. Concurrent
+// requests for the same instance wait for the first request's result.
+func getPostGameCarnageReport(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, instanceID int64) (*models.DestinyHistoricalStatsDestinyPostGameCarnageReportData, error) {
+	return pgcrCalls.do(instanceID, func() (*models.DestinyHistoricalStatsDestinyPostGameCarnageReportData, error) {
+		return fetchPostGameCarnageReport(api, auth, instanceID)
+	})
+}
+
+func fetchPostGameCarnageReport(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, instanceID int64) (*models.DestinyHistoricalStatsDestinyPostGameCarnageReportData, error) {
+	key := fmt.Sprintf("pgcr-%v", instanceID)
+	var pgcr models.DestinyHistoricalStatsDestinyPostGameCarnageReportData
+	ok, err := cache.Get(key, &pgcr)
+	if err != nil {
+		return nil, err
+	}
+	stats.recordCache(ok)
+	if ok {
+		return &pgcr, nil
+	}
+	if flagRequireCache {
+		return nil, cacheMiss(key)
+	}
+	detailLogger.Printf("getting post game carnage report for instance %v", instanceID)
+	params := destiny2.NewDestiny2GetPostGameCarnageReportParams()
+	params.SetActivityID(instanceID)
+	start := time.Now()
+	resp, err := api.Destiny2.Destiny2GetPostGameCarnageReport(params, auth)
+	stats.record("pgcr", start)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.Set(key, resp.Payload.Response, pgcrTTL); err != nil {
+		return nil, err
+	}
+	return resp.Payload.Response, nil
+}
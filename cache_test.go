@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+func TestPGCRGroup(t *testing.T) {
+	errFetch := errors.New("fetch failed")
+	tests := []struct {
+		name      string
+		instances int
+		requests  int
+		err       error
+	}{
+		{"one request", 1, 1, nil},
+		{"many requests", 1, 10, nil},
+		{"many instances", 3, 10, nil},
+		{"failed fetch", 1, 10, errFetch},
+	}
+	for _, tt := range tests {
+		var g pgcrGroup
+		var mu sync.Mutex
+		fetches := make(map[int64]int)
+		release := make(chan struct{})
+		var wg sync.WaitGroup
+		results := make([]*models.DestinyHistoricalStatsDestinyPostGameCarnageReportData, tt.instances*tt.requests)
+		errs := make([]error, len(results))
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				instanceID := int64(i % tt.instances)
+				results[i], errs[i] = g.do(instanceID, func() (*models.DestinyHistoricalStatsDestinyPostGameCarnageReportData, error) {
+					mu.Lock()
+					fetches[instanceID]++
+					mu.Unlock()
+					<-release
+					if tt.err != nil {
+						return nil, tt.err
+					}
+					return newPGCR([]int64{instanceID}), nil
+				})
+			}(i)
+		}
+		// Hold the fetches until every other request is waiting for one.
+		for waiting := 0; waiting < len(results)-tt.instances; {
+			time.Sleep(time.Millisecond)
+			g.mu.Lock()
+			waiting = 0
+			for _, call := range g.m {
+				waiting += call.dups
+			}
+			g.mu.Unlock()
+		}
+		close(release)
+		wg.Wait()
+
+		for instanceID := int64(0); instanceID < int64(tt.instances); instanceID++ {
+			if fetches[instanceID] != 1 {
+				t.Errorf("%v: instance %v fetched %v times; want 1", tt.name, instanceID, fetches[instanceID])
+			}
+		}
+		for i := range results {
+			instanceID := int64(i % tt.instances)
+			if errs[i] != tt.err {
+				t.Errorf("%v: request %v failed with %v; want %v", tt.name, i, errs[i], tt.err)
+				continue
+			}
+			if tt.err == nil && results[i].Entries[0].Player.DestinyUserInfo.MembershipID != instanceID {
+				t.Errorf("%v: request %v got the report for another instance", tt.name, i)
+			}
+		}
+		if len(g.m) != 0 {
+			t.Errorf("%v: %v fetches still in progress", tt.name, len(g.m))
+		}
+	}
+}
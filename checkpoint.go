@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+// checkpointFile records the progress of the completion scans so that a run
+// that fails part way through can resume where it left off. Each scan is keyed
+// by its clan, window, modes and result settings, so a scan of a different
+// window or with different settings starts over. A week that was completely
+// scanned isn't scanned again.
+type checkpointFile struct {
+	mu    sync.Mutex
+	path  string
+	scans map[string]json.RawMessage
+	// saved are the scans saved or resumed by this run. Only these are
+	// written, so that scans of old windows are dropped.
+	saved map[string]bool
+}
+
+// scanCheckpoint is the progress of a single completion scan.
+type scanCheckpoint struct {
+	// DoneMembers are the membership IDs of the clan members that have been
+	// fully scanned.
+	DoneMembers map[int64]bool               `json:"doneMembers"`
+	Results     map[ActivityMode]*modeResult `json:"results"`
+	// Complete is whether every clan member was scanned and the window had
+	// ended, so that the results are final.
+	Complete bool `json:"complete,omitempty"`
+}
+
+// loadCheckpointFile loads the checkpoint file, which need not exist. If path
+// is empty, checkpointing is disabled and nil is returned. With --refresh, the
+// file's scans are ignored and it's overwritten.
+func loadCheckpointFile(path string) (*checkpointFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	c := &checkpointFile{
+		path:  path,
+		scans: make(map[string]json.RawMessage),
+		saved: make(map[string]bool),
+	}
+	if flagRefresh {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.scans); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func getScanKey(clanID int64, start, end time.Time) string {
+	key := fmt.Sprintf("%v/%v/%v", clanID, start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
+	for _, m := range modes {
+		key += "/" + m.key
+	}
+	if flagActivityHash != 0 {
+		key += fmt.Sprintf("/activity=%v", flagActivityHash)
+	}
+	return key + "/" + getResultSettings()
+}
+
+// load returns the scan's progress, or nil if there is none.
+func (c *checkpointFile) load(clanID int64, start, end time.Time) (*scanCheckpoint, error) {
+	if c == nil {
+		return nil, nil
+	}
+	key := getScanKey(clanID, start, end)
+	c.mu.Lock()
+	data, ok := c.scans[key]
+	if ok {
+		// Keep the scan even if this run doesn't save it again, as when
+		// it's complete.
+		c.saved[key] = true
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	var scan scanCheckpoint
+	if err := json.Unmarshal(data, &scan); err != nil {
+		return nil, err
+	}
+	return &scan, nil
+}
+
+// save records the scan's progress and writes the checkpoint file.
+func (c *checkpointFile) save(clanID int64, start, end time.Time, scan *scanCheckpoint) error {
+	if c == nil {
+		return nil
+	}
+	data, err := json.Marshal(scan)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := getScanKey(clanID, start, end)
+	c.scans[key] = data
+	c.saved[key] = true
+	scans := make(map[string]json.RawMessage)
+	for key := range c.saved {
+		scans[key] = c.scans[key]
+	}
+	data, err = json.Marshal(scans)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, data, 0644)
+}
+
+type completionJSON struct {
+	Start           time.Time                  `json:"start"`
+	Duration        time.Duration              `json:"duration"`
+	End             time.Time                  `json:"end"`
+	FireteamMembers []*models.UserUserInfoCard `json:"fireteamMembers"`
+	ActivityHash    int64                      `json:"activityHash,omitempty"`
+	// IncompleteMembers are only set with --show-incomplete.
+	IncompleteMembers []*models.UserUserInfoCard `json:"incompleteMembers,omitempty"`
+	FireteamSize      int                        `json:"fireteamSize,omitempty"`
+	// Guests are only set with --show-guests.
+	Guests []*models.UserUserInfoCard `json:"guests,omitempty"`
+}
+
+func (c *completion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&completionJSON{c.start, c.duration, c.end, c.fireteamMembers, c.activityHash, c.incompleteMembers, c.fireteamSize, c.guests})
+}
+
+func (c *completion) UnmarshalJSON(data []byte) error {
+	var v completionJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*c = completion{v.Start, v.Duration, v.End, v.FireteamMembers, v.IncompleteMembers, v.ActivityHash, v.FireteamSize, v.Guests}
+	return nil
+}
+
+type modeResultJSON struct {
+	Seen     map[int64]bool `json:"seen"`
+	Earliest *completion    `json:"earliest"`
+	Fastest  *completion    `json:"fastest,omitempty"`
+	Count    int            `json:"count"`
+	Attempts []*completion  `json:"attempts"`
+}
+
+func (r *modeResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&modeResultJSON{r.seen, r.earliest, r.fastest, r.count, r.attempts})
+}
+
+func (r *modeResult) UnmarshalJSON(data []byte) error {
+	var v modeResultJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*r = modeResult{v.Seen, v.Earliest, v.Fastest, v.Count, v.Attempts}
+	return nil
+}
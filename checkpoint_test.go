@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointFileClans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	start := time.Date(2020, time.March, 10, 17, 0, 0, 0, time.UTC)
+	end := start.Add(weekPeriod)
+	tests := []struct {
+		name string
+		// reload is whether the checkpoint is loaded again between the
+		// clans, as each clan's report used to do in a batch.
+		reload    bool
+		wantClan1 bool
+	}{
+		{"loaded once", false, true},
+		{"loaded for each clan", true, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name+".json")
+		c, err := loadCheckpointFile(path)
+		if err != nil {
+			t.Fatalf("%v: loadCheckpointFile() failed: %v", tt.name, err)
+		}
+		if err := c.save(1, start, end, &scanCheckpoint{DoneMembers: map[int64]bool{1: true}}); err != nil {
+			t.Fatalf("%v: save() failed: %v", tt.name, err)
+		}
+		if tt.reload {
+			if c, err = loadCheckpointFile(path); err != nil {
+				t.Fatalf("%v: loadCheckpointFile() failed: %v", tt.name, err)
+			}
+		}
+		if err := c.save(2, start, end, &scanCheckpoint{DoneMembers: map[int64]bool{2: true}}); err != nil {
+			t.Fatalf("%v: save() failed: %v", tt.name, err)
+		}
+
+		saved, err := loadCheckpointFile(path)
+		if err != nil {
+			t.Fatalf("%v: loadCheckpointFile() failed: %v", tt.name, err)
+		}
+		for clanID, want := range map[int64]bool{1: tt.wantClan1, 2: true} {
+			scan, err := saved.load(clanID, start, end)
+			if err != nil {
+				t.Fatalf("%v: load(%v) failed: %v", tt.name, clanID, err)
+			}
+			if got := scan != nil; got != want {
+				t.Errorf("%v: clan %v's scan was kept = %v; want %v", tt.name, clanID, got, want)
+			} else if got && !scan.DoneMembers[clanID] {
+				t.Errorf("%v: clan %v's scan has done members %v", tt.name, clanID, scan.DoneMembers)
+			}
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	flagAPIKeys  apiKeysFlag
+	flagUsername string
+	flagClanName string
+	flagVerbose  bool
+	flagQuiet    bool
+	flagRedact   bool
+	flagStats    bool
+	flagSort     string
+	flagModes    string
+
+	flagMembershipID   int64
+	flagMembershipType int
+
+	flagPlatformPreference string
+
+	flagOAuthClientID     string
+	flagOAuthClientSecret string
+	flagTokenFile         string
+
+	flagOutputTemplate string
+
+	flagFormat     string
+	flagTemplate   string
+	flagTimezone   string
+	flagTimeFormat string
+
+	flagCountPresent bool
+	flagCountAll     bool
+
+	flagIncludeIncomplete bool
+	flagShowIncomplete    bool
+
+	flagStateFile     string
+	flagFailOnPartial bool
+	flagStrict        bool
+
+	flagStopWhenComplete bool
+
+	flagNoFireteamCheck bool
+
+	flagExportFormat string
+	flagExportOutput string
+
+	flagSummaryJSON bool
+
+	flagMembersStartPage int
+	flagMembersMaxPages  int
+
+	flagAPIKeyFile string
+
+	flagVerbosity int
+	flagLogLevel  int
+
+	flagClanMajority bool
+
+	flagRequireCache bool
+
+	flagIncludeMembers string
+	flagExcludeMembers string
+
+	flagWatch    bool
+	flagInterval time.Duration
+
+	flagNoFooter bool
+
+	flagPrintSchema bool
+
+	flagHistoryPageSize int
+
+	flagOnlyEarned   bool
+	flagOnlyUnearned bool
+
+	flagConsolidate bool
+	flagNoWeeks     bool
+
+	flagUserFile string
+
+	flagMaxMembers int
+
+	flagActivityHash int64
+
+	flagShowGuests bool
+
+	flagRefresh bool
+
+	flagMilestoneHash int64
+
+	flagProfileComponents string
+
+	flagServe      string
+	flagSinglePass bool
+
+	flagMaxPages    int
+	flagMembersFile string
+
+	flagConcurrency int
+	flagCheckpoint  string
+	flagResetAnchor string
+	flagSince       string
+	flagSeason      string
+	flagLateJoiners bool
+
+	flagPlatformSummary bool
+	flagTop             int
+
+	flagInstance int64
+
+	flagCacheDir      string
+	flagRosterTTL     time.Duration
+	flagRefreshRoster bool
+)
+
+// command is a subcommand of the tool.
+type command struct {
+	name  string
+	usage string
+	flags *flag.FlagSet
+	run   func()
+}
+
+func newCommand(name, usage string, run func(), addFlags ...func(*flag.FlagSet)) *command {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	addCommonFlags(fs)
+	for _, addFlag := range addFlags {
+		addFlag(fs)
+	}
+	return &command{name, usage, fs, run}
+}
+
+var commands = []*command{
+	newCommand("report", "report the clan's weekly rewards and completions (the default)", runReport, addReportFlags),
+	newCommand("members", "list the clan's members", runMembers, addMembersFlags),
+	newCommand("export-members", "write the clan's roster as CSV or JSON", runExportMembers, addExportFlags),
+	newCommand("whoami", "show the user's membership and clan", runWhoami),
+	newCommand("detail", "explain whether an activity instance counts as a clan completion", runDetail, addDetailFlags),
+	newCommand("modes", "list the activity modes that can be tracked", runModes),
+}
+
+func addCommonFlags(fs *flag.FlagSet) {
+	fs.Var(&flagAPIKeys, "apikey", "the Bungie API key; give it several times to rotate between keys when one is throttled")
+	fs.StringVar(&flagAPIKeyFile, "apikey-file", "", "a file of Bungie API keys, one per line, to rotate between")
+	fs.StringVar(&flagUsername, "user", "", "the user to query")
+	fs.Int64Var(&flagMembershipID, "membershipid", 0, "the membership ID of the user to query, instead of searching for --user")
+	fs.IntVar(&flagMembershipType, "membershiptype", 0, "the membership type of --membershipid (1 Xbox, 2 PSN, 3 Steam, ...)")
+	fs.Int64Var(&flagMembershipID, "membership-id", 0, "an alias for --membershipid")
+	fs.IntVar(&flagMembershipType, "membership-type", 0, "an alias for --membershiptype")
+	fs.StringVar(&flagClanName, "clan-name", "", "the name of the clan to query, instead of the user's clan")
+	fs.BoolVar(&flagVerbose, "verbose", false, "enable verbose output (the same as --log-level=3)")
+	fs.BoolVar(&flagQuiet, "quiet", false, "don't show progress")
+	fs.BoolVar(&flagRedact, "redact", false, "replace membership IDs in the logs and output with placeholders that are consistent within the run, e.g. to share them publicly")
+	fs.BoolVar(&flagStats, "stats", false, "print the number and duration of API calls at the end")
+
+	fs.StringVar(&flagPlatformPreference, "platform-preference", "steam", "the platforms to prefer, in order, when several memberships match the user (xbox, psn, steam, blizzard, stadia, epic)")
+
+	fs.StringVar(&flagOAuthClientID, "oauth-client-id", "", "the Bungie OAuth client ID; enables authenticated requests")
+	fs.StringVar(&flagOAuthClientSecret, "oauth-client-secret", "", "the Bungie OAuth client secret")
+	fs.StringVar(&flagTokenFile, "token-file", "token.json", "the file to store the OAuth token in")
+
+	addVerbosityFlags(fs)
+
+	fs.StringVar(&flagCacheDir, "cache-dir", "", "the directory to cache API responses in")
+	fs.DurationVar(&flagRosterTTL, "roster-ttl", 3*time.Hour, "how long a cached clan roster is valid")
+	fs.BoolVar(&flagRefreshRoster, "refresh-roster", false, "ignore any cached clan roster")
+	fs.BoolVar(&flagRequireCache, "require-cache", false, "fail instead of calling the API when a clan roster or post game carnage report isn't cached (the other requests still call the API)")
+
+	fs.IntVar(&flagMembersStartPage, "members-start-page", 1, "the page of clan members to start from, to resume a failed roster fetch (the roster isn't cached)")
+	fs.IntVar(&flagMembersMaxPages, "members-max-pages", maxMemberPages, "the most pages of clan members to get")
+}
+
+func addMembersFlags(fs *flag.FlagSet) {
+	fs.StringVar(&flagSort, "sort", "id", "the order to list clan members in (id, name, join)")
+	fs.StringVar(&flagFormat, "format", "text", "the output format (text, html, or ndjson or its alias jsonl)")
+	fs.StringVar(&flagOutputTemplate, "output-template", "", "a text/template to write the whole text report with, given .ClanID, .Roster, .Weeks (each with .Reward, .Completions and .Summary), .Contributors and .Leaderboard")
+	fs.StringVar(&flagTemplate, "template", "", "the template file to write the weeks with instead of the built-in one (text/template for --format=text, html/template for --format=html)")
+	fs.StringVar(&flagProfileComponents, "profile-components", "", "more profile components to get for each member, as IDs or names (e.g. 900 or Records for the triumph score)")
+}
+
+func addReportFlags(fs *flag.FlagSet) {
+	addMembersFlags(fs)
+	fs.BoolVar(&flagPrintSchema, "print-schema", false, "write the JSON Schema of the --format=ndjson lines and exit")
+	fs.StringVar(&flagModes, "modes", "raid,nightfall,trials,crucible", "the activity modes to track (raid, nightfall, trials, crucible, ironbanner, strikes)")
+	fs.Int64Var(&flagActivityHash, "activity-hash", 0, "only count completions of the activity with this hash (e.g. a specific raid), as well as of the mode")
+	fs.StringVar(&flagTimezone, "timezone", "Local", "the IANA time zone to show times in the text and html formats (e.g. America/New_York, UTC or Local)")
+	fs.StringVar(&flagTimeFormat, "time-format", "", "the Go layout to show times with")
+	fs.BoolVar(&flagOnlyEarned, "only-earned", false, "only list the reward entries that have been earned")
+	fs.BoolVar(&flagOnlyUnearned, "only-unearned", false, "only list the reward entries that haven't been earned yet")
+	fs.BoolVar(&flagCountAll, "count-all", false, "count all of the clan completions, not just the earliest")
+	fs.BoolVar(&flagIncludeIncomplete, "include-incomplete", false, "also report clan activities that weren't completed as attempts")
+	fs.BoolVar(&flagShowIncomplete, "show-incomplete", false, "also list the clan members in each completion's fireteam who didn't complete it")
+	fs.BoolVar(&flagShowGuests, "show-guests", false, "also list the players in each completion's fireteam who aren't in the clan now")
+	fs.BoolVar(&flagCountPresent, "count-present", false, "count clan members in the fireteam even if they didn't complete the activity")
+	fs.Int64Var(&flagMilestoneHash, "milestone-hash", defaultMilestoneHash, "the hash of the clan rewards milestone to use if the reward state's milestone can't be looked up")
+	fs.StringVar(&flagResetAnchor, "reset-anchor", defaultResetAnchor.Format(time.RFC3339), "a known weekly reset time (RFC 3339) that weeks are aligned to")
+	fs.StringVar(&flagCheckpoint, "checkpoint", "", "the file to record scan progress in, so that a failed run can resume without scanning the completed weeks again")
+	fs.BoolVar(&flagRefresh, "refresh", false, "ignore the progress recorded in --checkpoint and scan every week again")
+	fs.BoolVar(&flagSinglePass, "single-pass", false, "get each character's activity history once for all modes instead of once per mode")
+	fs.BoolVar(&flagClanMajority, "clan-majority", false, "count a completion if a majority of its fireteam were clan members, instead of a fixed number for each mode")
+	fs.BoolVar(&flagNoFireteamCheck, "no-fireteam-check", false, "count a completion by any single clan member, regardless of how many clan members were in the fireteam")
+	fs.BoolVar(&flagStopWhenComplete, "stop-when-complete", false, "don't scan the completions of weeks whose rewards have all been earned (unless --top, --consolidate, --platform-summary or --stats is given)")
+	fs.BoolVar(&flagStrict, "strict", false, "stop at the first clan member that fails instead of skipping them")
+	fs.BoolVar(&flagNoFooter, "no-footer", false, "don't write the members scanned, API calls and duration to stderr (or a meta line with --format=ndjson) at the end")
+	fs.BoolVar(&flagSummaryJSON, "summary-json", false, "write a JSON summary of the run to stderr at the end, even with --quiet")
+	fs.BoolVar(&flagFailOnPartial, "fail-on-partial", false, fmt.Sprintf("exit with status %d if any clan member was skipped", exitCodePartial))
+	fs.StringVar(&flagStateFile, "state-file", "", "the file to keep the reward state and scan results in between runs, to report newly earned rewards and only scan the activities since the last run")
+	fs.StringVar(&flagServe, "serve", "", "serve /metrics and an on-demand /report, as NDJSON (see --format=ndjson), on the address (e.g. :8080) instead of reporting once")
+	fs.BoolVar(&flagWatch, "watch", false, "rerun the report every --interval and write it again when it changes, until interrupted")
+	fs.DurationVar(&flagInterval, "interval", 5*time.Minute, "how often to rerun the report with --watch")
+	fs.StringVar(&flagUserFile, "user-file", "", "a file of users, one per line, to report each of their clans once")
+	fs.IntVar(&flagMaxMembers, "max-members", 0, "only scan the first clan members (after --sort and --members-file), as a quick partial sample")
+	fs.StringVar(&flagMembersFile, "members-file", "", "a file of display names or membership IDs, one per line, to only scan those clan members")
+	fs.StringVar(&flagIncludeMembers, "include-members", "", "only count these clan members toward clan fireteams (comma-separated membership IDs, or @file with one per line)")
+	fs.StringVar(&flagExcludeMembers, "exclude-members", "", "don't count these clan members toward clan fireteams (comma-separated membership IDs, or @file with one per line)")
+	fs.IntVar(&flagHistoryPageSize, "history-page-size", 100, fmt.Sprintf("the number of activities in each page of activity history (%d to %d)", minHistoryPageSize, maxHistoryPageSize))
+	fs.IntVar(&flagMaxPages, "max-pages", 50, "the most pages of activity history to get for each character and mode")
+	fs.IntVar(&flagConcurrency, "concurrency", 1, "the number of weeks to compute concurrently")
+	fs.StringVar(&flagSince, "since", "", "report the completions in the last days (e.g. 3d) or duration (e.g. 36h) instead of the reward weeks")
+	fs.StringVar(&flagSeason, "season", "", "report the completions for a whole season instead of weeks (\"current\" or a season number)")
+	fs.IntVar(&flagTop, "top", 0, "list the top contributors to the earliest clan completions")
+	fs.BoolVar(&flagConsolidate, "consolidate", false, "list every contributor to the earliest clan completions of all of the weeks, with their count for each mode")
+	fs.BoolVar(&flagNoWeeks, "no-weeks", false, "don't write each week's report, e.g. to only write the --consolidate leaderboard")
+	fs.BoolVar(&flagPlatformSummary, "platform-summary", false, "count the clan members on each platform who contributed to a clan completion")
+	fs.BoolVar(&flagLateJoiners, "late-joiners", false, "list members who joined the clan after the week started")
+}
+
+func addExportFlags(fs *flag.FlagSet) {
+	fs.StringVar(&flagSort, "sort", "id", "the order to list clan members in (id, name, join)")
+	fs.StringVar(&flagExportFormat, "format", "csv", "the format to write the roster in (csv, json)")
+	fs.StringVar(&flagExportOutput, "output", "", "the file to write the roster to instead of stdout")
+}
+
+func addDetailFlags(fs *flag.FlagSet) {
+	fs.Int64Var(&flagInstance, "instance", 0, "the activity instance ID")
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %v [command] [flags]\n\ncommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %v\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun \"%v <command> -help\" for the command's flags\n", os.Args[0])
+}
+
+func runMembers() {
+	out, err := newReportWriter(flagFormat, os.Stdout, time.UTC, "", flagTemplate, flagOutputTemplate)
+	if err != nil {
+		fatal(err)
+	}
+	profileComponents, err = parseProfileComponents(flagProfileComponents)
+	if err != nil {
+		fatal(err)
+	}
+	api, auth, _, err := newAPI()
+	if err != nil {
+		fatal(err)
+	}
+	_, clan, err := getUserAndClan(api, auth)
+	if err != nil {
+		fatal(err)
+	}
+	clanMembers, _, err := getCachedMembers(api, auth, clan.GroupID)
+	if err != nil {
+		fatal(err)
+	}
+	if len(clanMembers) == 0 && flagFormat == "text" {
+		fmt.Println("clan has no members")
+		return
+	} else if len(clanMembers) == 0 {
+		fmt.Fprintln(os.Stderr, "clan has no members")
+	}
+	if err := sortMembers(clanMembers, flagSort); err != nil {
+		fatal(err)
+	}
+	report := newRosterReport(clan.GroupID, clanMembers)
+	report.TriumphScores = getRosterTriumphScores(api, auth, clanMembers)
+	if flagFormat != "text" {
+		if err := out.WriteRoster(report); err != nil {
+			fatal(err)
+		}
+		if err := out.Flush(); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	w := redactWriter(os.Stdout)
+	for _, member := range report.Members {
+		fmt.Fprintf(w, "%v\t%v\t%v", member.UserInfo.MembershipID, getDisplayName(member.UserInfo), member.JoinDate.Format("2006-01-02"))
+		if score, ok := report.TriumphScores[member.UserInfo.MembershipID]; ok {
+			fmt.Fprintf(w, "\t%v", score)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func runModes() {
+	fmt.Printf("%-12s %-6s %-12s %-4s %v\n", "KEY", "CODE", "NAME", "PVP", "CLAN MEMBERS")
+	for _, m := range allModes {
+		pvp := "no"
+		if m.pvp {
+			pvp = "yes"
+		}
+		fmt.Printf("%-12s %-6d %-12s %-4s %d\n", m.key, int32(m.mode), m.name, pvp, getMinClanMembersNeeded(m.mode))
+	}
+}
+
+func runWhoami() {
+	api, auth, _, err := newAPI()
+	if err != nil {
+		fatal(err)
+	}
+	user, err := getUser(api, auth)
+	if err != nil {
+		fatal(err)
+	}
+	w := redactWriter(os.Stdout)
+	fmt.Fprintf(w, "Name:            %v\n", getDisplayName(user))
+	fmt.Fprintf(w, "Membership ID:   %v\n", user.MembershipID)
+	fmt.Fprintf(w, "Membership type: %v\n", user.MembershipType)
+	clan, err := getClan(api, auth, user)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(w, "Clan:            %v (%v)\n", clan.Name, clan.GroupID)
+}
+
+func main() {
+	// The command defaults to report so that the flags-only invocation still
+	// works.
+	name, args := "report", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	var cmd *command
+	for _, c := range commands {
+		if c.name == name {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+	cmd.flags.Parse(args)
+
+	if flagRedact {
+		redaction = newRedactor()
+	}
+	verbosity := getVerbosity()
+	setUpLoggers(verbosity)
+	progress = newProgressReporter(os.Stderr, verbosity == 0 && !flagQuiet)
+	var err error
+	cache, err = newCache(flagCacheDir)
+	if err != nil {
+		fatal(err)
+	}
+
+	cmd.run()
+	if verbosity > 0 || flagStats {
+		stats.Write(os.Stderr)
+		if apiKeys != nil {
+			apiKeys.Write(os.Stderr)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/zhirsch/destiny2-api/client"
+	"github.com/zhirsch/destiny2-api/client/destiny2"
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+// getPrimaryUserInfo returns the user info of the membership that is used for
+// the user's cross save profile.
+func getPrimaryUserInfo(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, user *models.UserUserInfoCard) (*models.UserUserInfoCard, error) {
+	memberLogger.Printf("getting cross save profile for destiny user %v (%q)", user.MembershipID, user.DisplayName)
+	params := destiny2.NewDestiny2GetProfileParams()
+	params.SetDestinyMembershipID(user.MembershipID)
+	params.SetMembershipType(int32(user.MembershipType))
+	params.SetComponents([]int64{100})
+	start := time.Now()
+	resp, err := api.Destiny2.Destiny2GetProfile(params, auth)
+	stats.record("get profile", start)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Payload.Response == nil || resp.Payload.Response.Profile == nil || resp.Payload.Response.Profile.Data == nil {
+		return user, nil
+	}
+	return resp.Payload.Response.Profile.Data.UserInfo, nil
+}
+
+// collapseSamePlayer reduces the memberships found by a search to one for each
+// player. Memberships are the same player if they have the same membership ID,
+// or if they're cross saved and have the same Bungie name and code, as a cross
+// save account is returned once for each of its platforms. Each player's
+// membership is the one cross save uses, if it was found, and otherwise the
+// first one. Memberships of a player who doesn't use cross save are separate
+// profiles, so they're kept for chooseDestinyUser to choose between.
+func collapseSamePlayer(users []*models.UserUserInfoCard) []*models.UserUserInfoCard {
+	var players []*models.UserUserInfoCard
+	for _, user := range users {
+		i := findSamePlayer(players, user)
+		if i < 0 {
+			players = append(players, user)
+			continue
+		}
+		logger.Printf("destiny user %v (%q) is the same player as %v (%q)", user.MembershipID, user.DisplayName, players[i].MembershipID, players[i].DisplayName)
+		if user.CrossSaveOverride != 0 && user.CrossSaveOverride == user.MembershipType {
+			players[i] = user
+		}
+	}
+	return players
+}
+
+// findSamePlayer returns the index of the membership in players that is the
+// same player as user, or -1 if there isn't one.
+func findSamePlayer(players []*models.UserUserInfoCard, user *models.UserUserInfoCard) int {
+	for i, player := range players {
+		if player.MembershipID == user.MembershipID {
+			return i
+		}
+		if user.CrossSaveOverride == 0 || player.CrossSaveOverride != user.CrossSaveOverride {
+			continue
+		}
+		if user.BungieGlobalDisplayName != "" && player.BungieGlobalDisplayName == user.BungieGlobalDisplayName && player.BungieGlobalDisplayNameCode == user.BungieGlobalDisplayNameCode {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveCrossSave replaces the user info of clan members whose platform
+// membership is overridden by cross save with their primary membership, and
+// drops members that are the same person as an earlier member, so that each
+// person is only counted once.
+func resolveCrossSave(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, members []*ClanMember) ([]*ClanMember, error) {
+	byPrimaryID := make(map[int64]*ClanMember)
+	var resolved []*ClanMember
+	for _, member := range members {
+		userInfo := member.UserInfo
+		if userInfo.CrossSaveOverride != 0 && userInfo.CrossSaveOverride != userInfo.MembershipType {
+			primary, err := getPrimaryUserInfo(api, auth, userInfo)
+			if err != nil {
+				return nil, err
+			}
+			if primary.MembershipID != userInfo.MembershipID {
+				member.UserInfo = primary
+				member.AlternateMembershipIDs = append(member.AlternateMembershipIDs, userInfo.MembershipID)
+			}
+		}
+		if existing, ok := byPrimaryID[member.UserInfo.MembershipID]; ok {
+			memberLogger.Printf("clan member %v (%q) is the same person as %v (%q)", userInfo.MembershipID, userInfo.DisplayName, existing.UserInfo.MembershipID, existing.UserInfo.DisplayName)
+			existing.AlternateMembershipIDs = append(existing.AlternateMembershipIDs, member.AlternateMembershipIDs...)
+			continue
+		}
+		byPrimaryID[member.UserInfo.MembershipID] = member
+		resolved = append(resolved, member)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+func TestCollapseSamePlayer(t *testing.T) {
+	// newUser returns a membership of the Bungie Name on the platform,
+	// cross saved to crossSaveOverride unless it's 0.
+	newUser := func(id, membershipType, crossSaveOverride int64, name string, code int32) *models.UserUserInfoCard {
+		return &models.UserUserInfoCard{
+			MembershipID:                id,
+			MembershipType:              membershipType,
+			CrossSaveOverride:           crossSaveOverride,
+			BungieGlobalDisplayName:     name,
+			BungieGlobalDisplayNameCode: code,
+		}
+	}
+	tests := []struct {
+		name  string
+		users []*models.UserUserInfoCard
+		want  []int64
+	}{
+		{"none", nil, nil},
+		{"one", []*models.UserUserInfoCard{newUser(1, 1, 0, "Name", 1234)}, []int64{1}},
+		{"same membership twice", []*models.UserUserInfoCard{
+			newUser(1, 1, 0, "Name", 1234),
+			newUser(1, 1, 0, "Name", 1234),
+		}, []int64{1}},
+		{"cross save on three platforms", []*models.UserUserInfoCard{
+			newUser(1, 1, 3, "Name", 1234),
+			newUser(2, 2, 3, "Name", 1234),
+			newUser(3, 3, 3, "Name", 1234),
+		}, []int64{3}},
+		{"cross save on three platforms, primary first", []*models.UserUserInfoCard{
+			newUser(3, 3, 3, "Name", 1234),
+			newUser(1, 1, 3, "Name", 1234),
+			newUser(2, 2, 3, "Name", 1234),
+		}, []int64{3}},
+		{"cross save without the primary", []*models.UserUserInfoCard{
+			newUser(1, 1, 3, "Name", 1234),
+			newUser(2, 2, 3, "Name", 1234),
+		}, []int64{1}},
+		{"no cross save keeps each profile", []*models.UserUserInfoCard{
+			newUser(1, 1, 0, "Name", 1234),
+			newUser(2, 2, 0, "Name", 1234),
+			newUser(3, 3, 0, "Name", 1234),
+		}, []int64{1, 2, 3}},
+		{"different codes", []*models.UserUserInfoCard{
+			newUser(1, 1, 1, "Name", 1234),
+			newUser(2, 1, 1, "Name", 5678),
+		}, []int64{1, 2}},
+		{"no Bungie Name", []*models.UserUserInfoCard{
+			newUser(1, 1, 1, "", 0),
+			newUser(2, 2, 1, "", 0),
+		}, []int64{1, 2}},
+	}
+	for _, tt := range tests {
+		if got := getMembershipIDs(collapseSamePlayer(tt.users)); !equalIDs(got, tt.want) {
+			t.Errorf("%v: collapseSamePlayer() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
-	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
+	"math"
 	"os"
 	"sort"
 	"strconv"
@@ -17,33 +17,225 @@ import (
 	db "github.com/zhirsch/destiny2-db"
 
 	"github.com/go-openapi/runtime"
-	runtime_client "github.com/go-openapi/runtime/client"
 	"github.com/zhirsch/destiny2-api/client"
 	"github.com/zhirsch/destiny2-api/client/group_v2"
 	"github.com/zhirsch/destiny2-api/models"
 )
 
 var (
-	flagAPIKey   = flag.String("apikey", "", "the Bungie API key")
-	flagUsername = flag.String("user", "", "the user to query")
-	flagVerbose  = flag.Bool("verbose", false, "enable verbose output")
+	logger      *log.Logger
+	progress    *progressReporter
+	cache       Cache
+	checkpoints *checkpointFile
+)
+
+// exitCodeMaintenance is the exit code when the Bungie API is in maintenance,
+// so that scheduled runs can tell it apart from real failures.
+const exitCodeMaintenance = 3
 
-	logger *log.Logger
+var (
+	// errInvalidAPIKey is returned when the Bungie API key is missing or
+	// rejected.
+	errInvalidAPIKey = errors.New("invalid or missing Bungie API key")
+	// errMaintenance is returned when the Bungie API is disabled for
+	// maintenance.
+	errMaintenance = errors.New("Bungie API is in maintenance")
 )
 
-func getDestinyUser(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, username string) (*models.UserUserInfoCard, error) {
-	logger.Printf("getting destiny user %q", username)
+// validateAPI makes a cheap API call to check that the API key works and that
+// the API isn't in maintenance, so that either fails fast instead of deep in
+// the crawl.
+func validateAPI(api *client.BungieNet, auth runtime.ClientAuthInfoWriter) error {
+	logger.Printf("validating the API key")
+	start := time.Now()
+	resp, err := api.Destiny2.Destiny2GetDestinyManifest(destiny2.NewDestiny2GetDestinyManifestParams(), auth)
+	stats.record("get manifest", start)
+	if err != nil {
+		if apiErr, ok := errors.Cause(err).(*runtime.APIError); ok && apiErr.Code == 401 {
+			return errInvalidAPIKey
+		}
+		return err
+	}
+	switch resp.Payload.ErrorCode {
+	case 2101, 2102: // ApiInvalidOrExpiredKey, ApiKeyMissingFromRequest
+		return errInvalidAPIKey
+	case 5, 1618: // SystemDisabled, DestinyUnexpectedError during maintenance
+		return errMaintenance
+	}
+	return nil
+}
+
+// getUser returns the user named by --user, or identified by --membershipid
+// and --membershiptype.
+func getUser(api *client.BungieNet, auth runtime.ClientAuthInfoWriter) (*models.UserUserInfoCard, error) {
+	if flagMembershipID == 0 {
+		if flagMembershipType != 0 {
+			return nil, errors.Errorf("--membershiptype needs --membershipid")
+		}
+		return getDestinyUser(api, auth, flagUsername)
+	}
+	if flagUsername != "" {
+		return nil, errors.Errorf("--user and --membershipid are mutually exclusive")
+	}
+	if flagMembershipType <= 0 {
+		return nil, errors.Errorf("--membershipid needs --membershiptype")
+	}
+	if _, ok := platformNames[int64(flagMembershipType)]; !ok {
+		return nil, errors.Errorf("unknown membership type %v", flagMembershipType)
+	}
+	return getDestinyUserByID(api, auth, flagMembershipID, int32(flagMembershipType))
+}
+
+// getDestinyUserByID returns the user with the membership ID, from their
+// profile.
+func getDestinyUserByID(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, membershipID int64, membershipType int32) (*models.UserUserInfoCard, error) {
+	logger.Printf("getting destiny user %v", membershipID)
+	params := destiny2.NewDestiny2GetProfileParams()
+	params.SetDestinyMembershipID(membershipID)
+	params.SetMembershipType(membershipType)
+	params.SetComponents([]int64{100})
+	start := time.Now()
+	resp, err := api.Destiny2.Destiny2GetProfile(params, auth)
+	stats.record("get profile", start)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Payload.Response == nil || resp.Payload.Response.Profile == nil || resp.Payload.Response.Profile.Data == nil || resp.Payload.Response.Profile.Data.UserInfo == nil {
+		return nil, errors.Errorf("no destiny user found with membership ID %v and type %v", membershipID, membershipType)
+	}
+	return resp.Payload.Response.Profile.Data.UserInfo, nil
+}
+
+// searchDestinyPlayer returns the players on any platform with the name.
+func searchDestinyPlayer(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, name string) ([]*models.UserUserInfoCard, error) {
 	params := destiny2.NewDestiny2SearchDestinyPlayerParams()
-	params.SetDisplayName(username)
+	params.SetDisplayName(name)
 	params.SetMembershipType(-1)
+	start := time.Now()
 	resp, err := api.Destiny2.Destiny2SearchDestinyPlayer(params, auth)
+	stats.record("search player", start)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
 	}
-	if len(resp.Payload.Response) != 1 {
-		return nil, errors.Errorf("found multiple destiny users named %q", username)
+	return resp.Payload.Response, nil
+}
+
+// noDestinyUserError returns the error for a username that no player has,
+// suggesting the names it might have meant if there are any.
+func noDestinyUserError(username string, suggestions []string) error {
+	if len(suggestions) > 0 {
+		return errors.Errorf("no exact match for %q; did you mean %v?", username, strings.Join(suggestions, ", "))
 	}
-	return resp.Payload.Response[0], nil
+	return errors.Errorf("no destiny player found for %q; try the Bungie Name form (Name#1234)", username)
+}
+
+// PlayerFinder looks up Destiny players by name.
+type PlayerFinder interface {
+	// Search returns the players on any platform with the name.
+	Search(name string) ([]*models.UserUserInfoCard, error)
+	// Suggest returns the Bungie Names of players with names close to the
+	// username.
+	Suggest(username string) []string
+	// Characters returns the player's characters.
+	Characters(user *models.UserUserInfoCard) ([]models.DestinyEntitiesCharactersDestinyCharacterComponent, error)
+}
+
+// apiPlayerFinder is a PlayerFinder that uses the API.
+type apiPlayerFinder struct {
+	api  *client.BungieNet
+	auth runtime.ClientAuthInfoWriter
+}
+
+func (f apiPlayerFinder) Search(name string) ([]*models.UserUserInfoCard, error) {
+	return searchDestinyPlayer(f.api, f.auth, name)
+}
+
+func (f apiPlayerFinder) Suggest(username string) []string {
+	return suggestDestinyUsers(f.api, f.auth, username)
+}
+
+func (f apiPlayerFinder) Characters(user *models.UserUserInfoCard) ([]models.DestinyEntitiesCharactersDestinyCharacterComponent, error) {
+	return getCharacters(f.api, f.auth, user)
+}
+
+func getDestinyUser(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, username string) (*models.UserUserInfoCard, error) {
+	return findDestinyUser(apiPlayerFinder{api, auth}, username)
+}
+
+// findDestinyUser returns the player with the username.
+func findDestinyUser(finder PlayerFinder, username string) (*models.UserUserInfoCard, error) {
+	logger.Printf("getting destiny user %q", username)
+	found, err := finder.Search(username)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, noDestinyUserError(username, finder.Suggest(username))
+	}
+	users := collapseSamePlayer(found)
+	if len(users) != 1 {
+		return chooseDestinyUser(finder, username, users)
+	}
+	return users[0], nil
+}
+
+// chooseDestinyUser picks one of the memberships found for the username. The
+// only membership on the first platform in --platform-preference is used, and
+// otherwise the only membership that has characters.
+func chooseDestinyUser(finder PlayerFinder, username string, users []*models.UserUserInfoCard) (*models.UserUserInfoCard, error) {
+	preference, err := parsePlatforms(flagPlatformPreference)
+	if err != nil {
+		return nil, err
+	}
+	for _, membershipType := range preference {
+		var found []*models.UserUserInfoCard
+		for _, user := range users {
+			if user.MembershipType == membershipType {
+				found = append(found, user)
+			}
+		}
+		if len(found) == 1 {
+			logger.Printf("chose the %v membership %v for %q", getPlatformName(membershipType), found[0].MembershipID, username)
+			return found[0], nil
+		}
+	}
+	var found []*models.UserUserInfoCard
+	for _, user := range users {
+		characters, err := finder.Characters(user)
+		if err != nil {
+			return nil, err
+		}
+		if len(characters) > 0 {
+			found = append(found, user)
+		}
+	}
+	if len(found) == 1 {
+		logger.Printf("chose the %v membership %v for %q because it has characters", getPlatformName(found[0].MembershipType), found[0].MembershipID, username)
+		return found[0], nil
+	}
+	var candidates []string
+	for _, user := range users {
+		candidates = append(candidates, fmt.Sprintf("%v %v", getPlatformName(user.MembershipType), user.MembershipID))
+	}
+	return nil, errors.Errorf("found %d destiny users named %q (%v); pick one with --platform-preference or --membershipid and --membershiptype", len(users), username, strings.Join(candidates, ", "))
+}
+
+// parsePlatforms returns the membership types of the platforms named in the
+// comma-separated list, in order.
+func parsePlatforms(list string) ([]int64, error) {
+	var membershipTypes []int64
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		membershipType, ok := findPlatform(name)
+		if !ok {
+			return nil, errors.Errorf("unknown platform %q", name)
+		}
+		membershipTypes = append(membershipTypes, membershipType)
+	}
+	return membershipTypes, nil
 }
 
 func getClan(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, user *models.UserUserInfoCard) (*models.GroupsV2GroupV2, error) {
@@ -53,7 +245,9 @@ func getClan(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, user *mod
 	params.SetGroupType(1)
 	params.SetMembershipID(user.MembershipID)
 	params.SetMembershipType(int32(user.MembershipType))
+	start := time.Now()
 	resp, err := api.GroupV2.GroupV2GetGroupsForMember(params, auth)
+	stats.record("get clan", start)
 	if err != nil {
 		return nil, err
 	}
@@ -63,323 +257,1568 @@ func getClan(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, user *mod
 	return resp.Payload.Response.Results[0].Group, nil
 }
 
-func getClanByDestinyUser(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, username string) (*models.GroupsV2GroupV2, error) {
-	user, err := getDestinyUser(api, auth, username)
+// getClanByName returns the clan whose name is exactly name, ignoring case.
+func getClanByName(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, name string) (*models.GroupsV2GroupV2, error) {
+	logger.Printf("searching for clan %q", name)
+	params := group_v2.NewGroupV2GroupSearchParams()
+	params.SetBody(&models.GroupsV2GroupQuery{
+		Name:         name,
+		GroupType:    1,
+		CurrentPage:  1,
+		ItemsPerPage: 25,
+	})
+	start := time.Now()
+	resp, err := api.GroupV2.GroupV2GroupSearch(params, auth)
+	stats.record("search clan", start)
 	if err != nil {
 		return nil, err
 	}
-	return getClan(api, auth, user)
+	var exact []*models.GroupsV2GroupV2Card
+	for _, result := range resp.Payload.Response.Results {
+		if strings.EqualFold(result.Name, name) {
+			exact = append(exact, result)
+		}
+	}
+	switch {
+	case len(exact) == 1:
+		return &models.GroupsV2GroupV2{
+			GroupID:     exact[0].GroupID,
+			Name:        exact[0].Name,
+			MemberCount: exact[0].MemberCount,
+		}, nil
+	case len(exact) > 1:
+		return nil, errors.Errorf("found multiple clans named %q: %v", name, formatClans(exact))
+	case len(resp.Payload.Response.Results) > 0:
+		return nil, errors.Errorf("no clan named %q; similar clans: %v", name, formatClans(resp.Payload.Response.Results))
+	default:
+		return nil, errors.Errorf("no clan named %q", name)
+	}
+}
+
+// formatClans lists the clans' names and group IDs.
+func formatClans(clans []*models.GroupsV2GroupV2Card) string {
+	var arr []string
+	for _, clan := range clans {
+		arr = append(arr, fmt.Sprintf("%v (%v)", clan.Name, clan.GroupID))
+	}
+	return strings.Join(arr, ", ")
+}
+
+// getUserAndClan returns the user and their clan, or just the clan named by
+// --clan-name if it's set.
+func getUserAndClan(api *client.BungieNet, auth runtime.ClientAuthInfoWriter) (*models.UserUserInfoCard, *models.GroupsV2GroupV2, error) {
+	if batchTarget != nil {
+		return batchTarget.user, batchTarget.clan, nil
+	}
+	if flagClanName != "" {
+		clan, err := getClanByName(api, auth, flagClanName)
+		return nil, clan, err
+	}
+	user, err := getUser(api, auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	clan, err := getClan(api, auth, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, clan, nil
 }
 
 func getCharacters(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, user *models.UserUserInfoCard) ([]models.DestinyEntitiesCharactersDestinyCharacterComponent, error) {
-	logger.Printf("getting characters for destiny user %v (%q)", user.MembershipID, user.DisplayName)
+	memberLogger.Printf("getting characters for destiny user %v (%q)", user.MembershipID, user.DisplayName)
 	params := destiny2.NewDestiny2GetProfileParams()
 	params.SetDestinyMembershipID(user.MembershipID)
 	params.SetMembershipType(int32(user.MembershipType))
-	params.SetComponents([]int64{200})
+	params.SetComponents(profileComponents)
+	start := time.Now()
 	resp, err := api.Destiny2.Destiny2GetProfile(params, auth)
+	stats.record("get profile", start)
 	if err != nil {
 		return nil, err
 	}
+	if resp.Payload.Response != nil {
+		profiles.record(user, resp.Payload.Response)
+	}
+	return getProfileCharacters(user, resp.Payload.Response), nil
+}
+
+// getProfileCharacters returns the characters in the user's profile, which is
+// none if the profile or its characters are missing.
+func getProfileCharacters(user *models.UserUserInfoCard, profile *models.DestinyResponsesDestinyProfileResponse) []models.DestinyEntitiesCharactersDestinyCharacterComponent {
 	var characters []models.DestinyEntitiesCharactersDestinyCharacterComponent
-	if resp.Payload.Response == nil {
-		logger.Printf("no characters for user %v (%q)", user.MembershipID, user.DisplayName)
-		return characters, nil
+	if profile == nil {
+		memberLogger.Printf("no characters for user %v (%q)", user.MembershipID, user.DisplayName)
+		return characters
 	}
-	for _, v := range resp.Payload.Response.Characters.Data {
+	if profile.Characters == nil || len(profile.Characters.Data) == 0 {
+		memberLogger.Printf("member has no characters: %v (%q)", user.MembershipID, user.DisplayName)
+		return characters
+	}
+	for _, v := range profile.Characters.Data {
 		characters = append(characters, v)
 	}
-	return characters, nil
+	return characters
 }
 
-func getMembers(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, groupID int64) ([]*models.UserUserInfoCard, error) {
-	var currentPage int32 = 1
-	var members []*models.UserUserInfoCard
-	for {
-		logger.Printf("getting clan members (page %v)", currentPage)
+// ClanMember is a member of the clan.
+type ClanMember struct {
+	UserInfo   *models.UserUserInfoCard `json:"userInfo"`
+	JoinDate   time.Time                `json:"joinDate"`
+	MemberType int64                    `json:"memberType"`
+	IsOnline   bool                     `json:"isOnline"`
+	// LastOnlineStatusChange is when the member last came online or went
+	// offline.
+	LastOnlineStatusChange time.Time `json:"lastOnlineStatusChange"`
+	// AlternateMembershipIDs are the member's other platform memberships
+	// that are overridden by cross save.
+	AlternateMembershipIDs []int64 `json:"alternateMembershipIds,omitempty"`
+}
+
+// getClanMemberIDs returns the set of the clan members' membership IDs,
+// including their alternate memberships.
+func getClanMemberIDs(members []*ClanMember) map[int64]bool {
+	clanMemberIDs := make(map[int64]bool)
+	for _, member := range members {
+		clanMemberIDs[member.UserInfo.MembershipID] = true
+		for _, id := range member.AlternateMembershipIDs {
+			clanMemberIDs[id] = true
+		}
+	}
+	return clanMemberIDs
+}
+
+// maxMemberPages is the default for the most pages of clan members that are
+// fetched, in case the API keeps saying there are more.
+const maxMemberPages = 100
+
+// getMembers returns the clan members, and whether they're only part of the
+// clan (see collectMembers).
+func getMembers(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, groupID int64) ([]*ClanMember, bool, error) {
+	return collectMembers(func(page int32) (*models.SearchResultOfGroupMember, error) {
 		params := group_v2.NewGroupV2GetMembersOfGroupParams()
-		params.SetCurrentpage(currentPage)
+		params.SetCurrentpage(page)
 		params.SetGroupID(groupID)
+		callStart := time.Now()
 		resp, err := api.GroupV2.GroupV2GetMembersOfGroup(params, auth)
+		stats.record("get members", callStart)
 		if err != nil {
 			return nil, err
 		}
-		for _, result := range resp.Payload.Response.Results {
-			members = append(members, result.DestinyUserInfo)
+		return resp.Payload.Response, nil
+	})
+}
+
+// collectMembers returns the clan members on the pages returned by getPage,
+// starting from --members-start-page and stopping when there are no more, a
+// page is empty, or --members-max-pages have been fetched. It also returns
+// whether the members are only part of the clan, because they didn't start
+// from the first page or stopped while there were more.
+func collectMembers(getPage func(page int32) (*models.SearchResultOfGroupMember, error)) ([]*ClanMember, bool, error) {
+	if flagMembersStartPage < 1 {
+		return nil, false, errors.Errorf("invalid members start page %v", flagMembersStartPage)
+	}
+	currentPage := int32(flagMembersStartPage)
+	partial := currentPage > 1
+	var members []*ClanMember
+	for pages := 1; ; pages++ {
+		memberLogger.Printf("getting clan members (page %v)", currentPage)
+		response, err := getPage(currentPage)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "getting clan members page %v (resume with --members-start-page %v)", currentPage, currentPage)
+		}
+		if members == nil {
+			members = make([]*ClanMember, 0, response.TotalResults)
+		}
+		for _, result := range response.Results {
+			members = append(members, &ClanMember{
+				UserInfo:               result.DestinyUserInfo,
+				JoinDate:               time.Time(result.JoinDate),
+				MemberType:             result.MemberType,
+				IsOnline:               result.IsOnline,
+				LastOnlineStatusChange: time.Unix(result.LastOnlineStatusChange, 0).UTC(),
+			})
+		}
+		memberLogger.Printf("got %v of %v clan members", len(members), response.TotalResults)
+		if !response.HasMore {
+			break
 		}
-		if !resp.Payload.Response.HasMore {
+		// Written whatever the verbosity, since the report is only of part
+		// of the clan.
+		if len(response.Results) == 0 {
+			fmt.Fprintf(redactWriter(os.Stderr), "warning: page %v has no clan members but says there are more; the roster is partial (resume with --members-start-page %v)\n", currentPage, currentPage+1)
+			partial = true
+			break
+		}
+		if pages >= flagMembersMaxPages {
+			fmt.Fprintf(redactWriter(os.Stderr), "warning: stopped after %v pages of clan members; the roster is partial (resume with --members-start-page %v)\n", pages, currentPage+1)
+			partial = true
 			break
 		}
 		currentPage++
 	}
 	logger.Printf("found %v members", len(members))
-	return members, nil
+	return members, partial, nil
 }
 
 func getRewards(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, groupID int64) (*models.DestinyMilestonesDestinyMilestone, error) {
 	logger.Printf("getting clan reward status for clan %v", groupID)
 	params := destiny2.NewDestiny2GetClanWeeklyRewardStateParams()
 	params.SetGroupID(groupID)
+	start := time.Now()
 	resp, err := api.Destiny2.Destiny2GetClanWeeklyRewardState(params, auth)
+	stats.record("get rewards", start)
 	if err != nil {
 		return nil, err
 	}
+	if resp.Payload.Response == nil {
+		return nil, errors.Errorf("no reward state for clan %v", groupID)
+	}
 	return resp.Payload.Response, nil
 }
 
-func getActivities(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, start, end time.Time, user *models.UserUserInfoCard, character models.DestinyEntitiesCharactersDestinyCharacterComponent, mode int32) ([]*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup, error) {
+// defaultMilestoneHash is the hash of the clan weekly rewards milestone that is
+// used if the reward state's milestone can't be looked up, unless
+// --milestone-hash is given.
+const defaultMilestoneHash = 4253138191
+
+// getMilestoneDefinition returns the definition of the clan rewards milestone
+// in the reward state, falling back to the --milestone-hash milestone if it
+// can't be found.
+func getMilestoneDefinition(manifest *db.DB, rewards *models.DestinyMilestonesDestinyMilestone) (*models.DestinyDefinitionsMilestonesDestinyMilestoneDefinition, error) {
+	if flagMilestoneHash <= 0 || flagMilestoneHash > math.MaxUint32 {
+		return nil, errors.Errorf("invalid --milestone-hash %v", flagMilestoneHash)
+	}
+	if rewards.MilestoneHash != 0 && rewards.MilestoneHash != flagMilestoneHash {
+		milestoneDefinitionInterface, err := getDefinition(manifest, "DestinyMilestoneDefinition", uint32(rewards.MilestoneHash), &models.DestinyDefinitionsMilestonesDestinyMilestoneDefinition{})
+		if err == nil {
+			return milestoneDefinitionInterface.(*models.DestinyDefinitionsMilestonesDestinyMilestoneDefinition), nil
+		}
+		logger.Printf("warning: unable to get the definition of milestone %v, using %v instead: %v", rewards.MilestoneHash, flagMilestoneHash, err)
+	}
+	milestoneDefinitionInterface, err := getDefinition(manifest, "DestinyMilestoneDefinition", uint32(flagMilestoneHash), &models.DestinyDefinitionsMilestonesDestinyMilestoneDefinition{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get the definition of milestone %v (see --milestone-hash)", flagMilestoneHash)
+	}
+	return milestoneDefinitionInterface.(*models.DestinyDefinitionsMilestonesDestinyMilestoneDefinition), nil
+}
+
+func getActivities(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, start, end time.Time, user *models.UserUserInfoCard, character models.DestinyEntitiesCharactersDestinyCharacterComponent, mode ActivityMode) ([]*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup, error) {
 	params := operations.NewDestiny2GetActivityHistoryParams()
 	params.SetCharacterID(character.CharacterID)
 	params.SetDestinyMembershipID(user.MembershipID)
 	params.SetMembershipType(int32(user.MembershipType))
-	var count int32 = 100
+	count := getHistoryPageSize()
 	params.SetCount(&count)
-	params.SetMode(&mode)
+	apiMode := int32(mode)
+	params.SetMode(&apiMode)
 	var page int32
 	var activities []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup
 	for {
-		logger.Printf("getting %v activities for character %v of destiny user %v (%q) page %v", mode, character.CharacterID, user.MembershipID, user.DisplayName, page)
+		memberLogger.Printf("getting %v activities for character %v of destiny user %v (%q) page %v", mode, character.CharacterID, user.MembershipID, user.DisplayName, page)
 		params.SetPage(&page)
+		callStart := time.Now()
 		resp, err := api.Operations.Destiny2GetActivityHistory(params, auth)
+		stats.record("activity history", callStart)
 		if err != nil {
 			return nil, err
 		}
-		found := false
-		for _, activity := range resp.Payload.Response.Activities {
-			startTime := time.Time(activity.Period)
-			if startTime.Before(start) {
-				continue
-			}
-			endTime := startTime.Add(time.Duration(activity.Values["activityDurationSeconds"].Basic.Value) * time.Second)
-			if endTime.After(end) {
-				continue
-			}
-			activities = append(activities, activity)
-			found = true
-		}
-		if !found {
+		inWindow, predates := filterActivityWindow(resp.Payload.Response.Activities, start, end)
+		activities = append(activities, inWindow...)
+		if predates {
 			break
 		}
 		if len(resp.Payload.Response.Activities) < int(count) {
 			break
 		}
 		page++
+		if int(page) >= flagMaxPages {
+			logger.Printf("warning: stopped after %v pages of %v activities for character %v of destiny user %v (%q)", page, mode, character.CharacterID, user.MembershipID, user.DisplayName)
+			break
+		}
 	}
 	return activities, nil
 }
-func getFireteam(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, instanceID int64) ([]*models.UserUserInfoCard, error) {
-	logger.Printf("getting fireteam for instance %v", instanceID)
-	params := destiny2.NewDestiny2GetPostGameCarnageReportParams()
-	params.SetActivityID(instanceID)
-	resp, err := api.Destiny2.Destiny2GetPostGameCarnageReport(params, auth)
+
+// filterActivityWindow returns the activities, which are newest first, that
+// are in the window, and whether the page reached activities that ended before
+// it. An activity is in the window if it ended in the window, even if it
+// started before it. A character's activities don't overlap, so once one ends
+// before the window the rest do too.
+func filterActivityWindow(activities []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup, start, end time.Time) ([]*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup, bool) {
+	var inWindow []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup
+	for _, activity := range activities {
+		startTime, duration, ok := getActivityTimes(activity)
+		if !ok {
+			logger.Printf("warning: skipping instance %v with an invalid period or duration", activity.ActivityDetails.InstanceID)
+			continue
+		}
+		endTime := startTime.Add(duration)
+		if endTime.Before(start) {
+			return inWindow, true
+		}
+		if endTime.After(end) {
+			continue
+		}
+		inWindow = append(inWindow, activity)
+	}
+	return inWindow, false
+}
+
+// getActivityTimes returns when the activity started and how long it took. It
+// returns false if the activity's record doesn't have a valid start time or
+// duration, such as a zero period or a duration too large for a time.Duration.
+func getActivityTimes(activity *models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup) (time.Time, time.Duration, bool) {
+	start := time.Time(activity.Period)
+	if start.IsZero() {
+		return time.Time{}, 0, false
+	}
+	value, ok := activity.Values["activityDurationSeconds"]
+	if !ok || value.Basic == nil {
+		return time.Time{}, 0, false
+	}
+	seconds := value.Basic.Value
+	if math.IsNaN(seconds) || seconds < 0 || seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return time.Time{}, 0, false
+	}
+	return start, time.Duration(seconds) * time.Second, true
+}
+
+// The range of the number of activities in a page of activity history that the
+// API accepts.
+const (
+	minHistoryPageSize = 1
+	maxHistoryPageSize = 250
+)
+
+// getHistoryPageSize returns --history-page-size clamped to what the API
+// accepts.
+func getHistoryPageSize() int32 {
+	switch {
+	case flagHistoryPageSize < minHistoryPageSize:
+		return minHistoryPageSize
+	case flagHistoryPageSize > maxHistoryPageSize:
+		return maxHistoryPageSize
+	}
+	return int32(flagHistoryPageSize)
+}
+
+// activityHistory gets the activities of a user's characters in a window. In
+// single pass mode, each character's history is fetched once for all modes and
+// then filtered by mode, instead of being fetched once per mode.
+type activityHistory struct {
+	user       *models.UserUserInfoCard
+	singlePass bool
+	// fetch gets the character's activities of the mode in the window.
+	fetch func(character models.DestinyEntitiesCharactersDestinyCharacterComponent, mode ActivityMode) ([]*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup, error)
+	// all are the activities of all modes, by character ID.
+	all map[int64][]*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup
+}
+
+func newActivityHistory(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, start, end time.Time, user *models.UserUserInfoCard, singlePass bool) *activityHistory {
+	return &activityHistory{
+		user:       user,
+		singlePass: singlePass,
+		fetch: func(character models.DestinyEntitiesCharactersDestinyCharacterComponent, mode ActivityMode) ([]*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup, error) {
+			return getActivities(api, auth, start, end, user, character, mode)
+		},
+		all: make(map[int64][]*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup),
+	}
+}
+
+// get returns the character's activities of the mode.
+func (h *activityHistory) get(character models.DestinyEntitiesCharactersDestinyCharacterComponent, mode ActivityMode) ([]*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup, error) {
+	if !h.singlePass {
+		return h.fetch(character, mode)
+	}
+	all, ok := h.all[character.CharacterID]
+	if !ok {
+		var err error
+		all, err = h.fetch(character, ModeAll)
+		if err != nil {
+			return nil, err
+		}
+		h.all[character.CharacterID] = all
+	}
+	var activities []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup
+	for _, activity := range all {
+		if hasMode(activity.ActivityDetails, mode) {
+			activities = append(activities, activity)
+		}
+	}
+	return activities, nil
+}
+
+// hasMode returns whether the activity is of the mode, either directly or
+// because the mode is one of the activity's parent modes.
+func hasMode(activity *models.DestinyHistoricalStatsDestinyHistoricalStatsActivity, mode ActivityMode) bool {
+	if ActivityMode(activity.Mode) == mode {
+		return true
+	}
+	for _, m := range activity.Modes {
+		if ActivityMode(m) == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// getFireteam returns all of the players on the clan's team in the activity,
+// clan members or not, and the total number of players on it. If
+// completedOnly is set, players that didn't complete the activity are skipped,
+// but are still counted in the total. Callers pick out the clan members with
+// extractClanFireteam.
+func getFireteam(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, instanceID int64, clanMemberIDs map[int64]bool, completedOnly bool) ([]*models.UserUserInfoCard, int, error) {
+	detailLogger.Printf("getting fireteam for instance %v", instanceID)
+	pgcr, err := getPostGameCarnageReport(api, auth, instanceID)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	pgcr = getClanTeam(pgcr, clanMemberIDs)
+	return getPGCRFireteam(pgcr, completedOnly), len(pgcr.Entries), nil
+}
+
+// getClanTeam returns the post game carnage report with only the entries on
+// the team with the most clan members. A PvP report includes the opposing
+// team, which isn't part of the clan's fireteam. A report whose entries have
+// no team, as in PvE, is returned as is.
+func getClanTeam(pgcr *models.DestinyHistoricalStatsDestinyPostGameCarnageReportData, clanMemberIDs map[int64]bool) *models.DestinyHistoricalStatsDestinyPostGameCarnageReportData {
+	clanMembers := make(map[float64]int)
+	for _, entry := range pgcr.Entries {
+		team, ok := entry.Values["team"]
+		if !ok || team.Basic == nil {
+			return pgcr
+		}
+		if clanMemberIDs[entry.Player.DestinyUserInfo.MembershipID] {
+			clanMembers[team.Basic.Value]++
+		}
+	}
+	if len(clanMembers) == 0 {
+		return pgcr
+	}
+	// Ties go to the lower team, so that the choice doesn't depend on the
+	// order of the map.
+	var clanTeam float64
+	most := 0
+	for team, n := range clanMembers {
+		if n > most || (n == most && team < clanTeam) {
+			clanTeam, most = team, n
+		}
+	}
+	teamReport := *pgcr
+	teamReport.Entries = nil
+	for _, entry := range pgcr.Entries {
+		if entry.Values["team"].Basic.Value == clanTeam {
+			teamReport.Entries = append(teamReport.Entries, entry)
+		}
+	}
+	return &teamReport
+}
+
+// getPGCRFireteam returns the players in the post game carnage report,
+// skipping those that didn't complete the activity if completedOnly is set.
+func getPGCRFireteam(pgcr *models.DestinyHistoricalStatsDestinyPostGameCarnageReportData, completedOnly bool) []*models.UserUserInfoCard {
 	var fireteam []*models.UserUserInfoCard
-	for _, entry := range resp.Payload.Response.Entries {
-		if entry.Values["completed"].Basic.Value == 0 {
+	for _, entry := range pgcr.Entries {
+		if completedOnly && entry.Values["completed"].Basic.Value == 0 {
 			continue
 		}
 		fireteam = append(fireteam, entry.Player.DestinyUserInfo)
 	}
-	return fireteam, nil
+	return fireteam
+}
+
+// getJoinedAfter returns the names of the members who joined the clan after t,
+// and so couldn't have contributed before it.
+func getJoinedAfter(members []*ClanMember, t time.Time) []string {
+	var names []string
+	for _, member := range members {
+		if member.JoinDate.After(t) {
+			names = append(names, getDisplayName(member.UserInfo))
+		}
+	}
+	return names
+}
+
+// platformNames are the names of the membership types.
+var platformNames = map[int64]string{
+	1:   "Xbox",
+	2:   "PSN",
+	3:   "Steam",
+	4:   "Blizzard",
+	5:   "Stadia",
+	6:   "Epic",
+	254: "BungieNext",
+}
+
+// findPlatform returns the membership type of the named platform, and false if
+// there's no such platform.
+func findPlatform(name string) (int64, bool) {
+	for membershipType, platformName := range platformNames {
+		if strings.EqualFold(platformName, name) {
+			return membershipType, true
+		}
+	}
+	return 0, false
+}
+
+func getPlatformName(membershipType int64) string {
+	if name, ok := platformNames[membershipType]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown (%v)", membershipType)
+}
+
+// getPlatformSummary returns the number of distinct clan members on each
+// platform that were in the fireteam of a clan completion.
+func getPlatformSummary(results map[ActivityMode]*modeResult) map[string]int {
+	seen := make(map[int64]bool)
+	platforms := make(map[string]int)
+	for _, result := range results {
+		if result.earliest == nil {
+			continue
+		}
+		for _, fireteamMember := range result.earliest.fireteamMembers {
+			if seen[fireteamMember.MembershipID] {
+				continue
+			}
+			seen[fireteamMember.MembershipID] = true
+			platforms[getPlatformName(fireteamMember.MembershipType)]++
+		}
+	}
+	return platforms
 }
 
-type byMembershipID []*models.UserUserInfoCard
+type byMembershipID []*ClanMember
 
-func (b byMembershipID) Len() int           { return len(b) }
-func (b byMembershipID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
-func (b byMembershipID) Less(i, j int) bool { return b[i].MembershipID < b[j].MembershipID }
+func (b byMembershipID) Len() int      { return len(b) }
+func (b byMembershipID) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byMembershipID) Less(i, j int) bool {
+	return b[i].UserInfo.MembershipID < b[j].UserInfo.MembershipID
+}
+
+type byDisplayName []*ClanMember
+
+func (b byDisplayName) Len() int      { return len(b) }
+func (b byDisplayName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byDisplayName) Less(i, j int) bool {
+	return strings.ToLower(getDisplayName(b[i].UserInfo)) < strings.ToLower(getDisplayName(b[j].UserInfo))
+}
+
+type byJoinDate []*ClanMember
+
+func (b byJoinDate) Len() int           { return len(b) }
+func (b byJoinDate) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byJoinDate) Less(i, j int) bool { return b[i].JoinDate.Before(b[j].JoinDate) }
+
+// sortMembers sorts the clan members by membership ID ("id"), display name
+// ("name"), or join date ("join").
+func sortMembers(members []*ClanMember, order string) error {
+	switch order {
+	case "id":
+		sort.Sort(byMembershipID(members))
+	case "name":
+		sort.Stable(byDisplayName(members))
+	case "join":
+		sort.Stable(byJoinDate(members))
+	default:
+		return errors.Errorf("unknown sort order %q", order)
+	}
+	return nil
+}
 
 type completion struct {
+	start           time.Time
+	duration        time.Duration
 	end             time.Time
 	fireteamMembers []*models.UserUserInfoCard
+	// incompleteMembers are the clan members who were in the activity but
+	// didn't complete it. They are only collected with --show-incomplete.
+	incompleteMembers []*models.UserUserInfoCard
+	// activityHash is the hash of the activity's definition.
+	activityHash int64
+	// fireteamSize is the number of players on the clan's team, clan members
+	// or not.
+	fireteamSize int
+	// guests are the players in the fireteam who aren't clan members. They
+	// are only collected with --show-guests.
+	guests []*models.UserUserInfoCard
+}
+
+func (c *completion) getFireteamNames() []string {
+	return getSortedNames(c.fireteamMembers)
+}
+
+func (c *completion) getIncompleteNames() []string {
+	return getSortedNames(c.incompleteMembers)
+}
+
+func (c *completion) getGuestNames() []string {
+	return getSortedNames(c.guests)
 }
 
-func (c *completion) getFireteamAsString() string {
+// isCarry returns whether the clan members were a minority of the fireteam,
+// e.g. a clan member carried by randoms. It's only an annotation; the
+// completion still counts if enough clan members were in it.
+func (c *completion) isCarry() bool {
+	return 2*len(c.fireteamMembers) < c.fireteamSize
+}
+
+// getDisplayName returns the user's Bungie Name (e.g. "Name#0123") if they
+// have one, and otherwise their platform display name.
+func getDisplayName(user *models.UserUserInfoCard) string {
+	if user.BungieGlobalDisplayName == "" {
+		return user.DisplayName
+	}
+	return fmt.Sprintf("%v#%04d", user.BungieGlobalDisplayName, user.BungieGlobalDisplayNameCode)
+}
+
+func getSortedNames(users []*models.UserUserInfoCard) []string {
 	var arr []string
-	for _, fireteamMember := range c.fireteamMembers {
-		arr = append(arr, fireteamMember.DisplayName)
+	for _, user := range users {
+		arr = append(arr, getDisplayName(user))
 	}
 	sort.Strings(arr)
-	return strings.Join(arr, ",")
+	return arr
+}
+
+// getIncompleteMembers returns the members of the fireteam who aren't in
+// completed.
+func getIncompleteMembers(fireteam, completed []*models.UserUserInfoCard) []*models.UserUserInfoCard {
+	completedIDs := make(map[int64]bool)
+	for _, user := range completed {
+		completedIDs[user.MembershipID] = true
+	}
+	var incomplete []*models.UserUserInfoCard
+	for _, user := range fireteam {
+		if !completedIDs[user.MembershipID] {
+			incomplete = append(incomplete, user)
+		}
+	}
+	return incomplete
+}
+
+// isVictory returns whether the activity stats show a victory, and false for
+// ok if they don't say.
+func isVictory(values map[string]models.DestinyHistoricalStatsDestinyHistoricalStatsValue) (victory, ok bool) {
+	if standing, ok := values["standing"]; ok {
+		return standing.Basic.Value == 0, true
+	}
+	if completionReason, ok := values["completionReason"]; ok {
+		return completionReason.Basic.Value == 0, true
+	}
+	return false, false
 }
 
-func getEarliestClanCompletion(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, start, end time.Time, clanMemberIDs map[int64]bool, clanMember *models.UserUserInfoCard, characters []models.DestinyEntitiesCharactersDestinyCharacterComponent, mode int32, earliest *completion) (*completion, error) {
+// isQualifyingCompletion returns whether the activity of the mode was
+// completed and won. Completing a PvE activity is winning it, but a PvP match
+// can be completed and lost.
+func isQualifyingCompletion(activity *models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup, mode ActivityMode) bool {
+	if activity.Values["completed"].Basic.Value == 0 {
+		return false
+	}
+	if m, ok := findMode(mode); ok && !m.pvp {
+		return true
+	}
+	victory, ok := isVictory(activity.Values)
+	if !ok {
+		logger.Panicf("unknown victory state for activity %v", activity.ActivityDetails.InstanceID)
+	}
+	return victory
+}
+
+// getMinClanMembersNeeded returns the number of clan members that must be in
+// the fireteam for a completion of the mode to count. With --no-fireteam-check,
+// a completion by any single clan member counts.
+func getMinClanMembersNeeded(mode ActivityMode) int {
+	if flagNoFireteamCheck {
+		return 1
+	}
+	switch mode {
+	case ModeRaid:
+		return 3
+	case ModeNightfall, ModeTrials, ModeCrucible, ModeIronBanner, ModeStrikes:
+		return 2
+	default:
+		logger.Panicf("unknown mode: %v", mode)
+	}
+	return 0
+}
+
+// getClanMembersNeeded returns the number of clan members that must be in a
+// fireteam of the size for a completion of the mode to count. With
+// --clan-majority, it's a majority of the fireteam instead of a fixed number.
+func getClanMembersNeeded(mode ActivityMode, fireteamSize int) int {
+	if !flagClanMajority || flagNoFireteamCheck {
+		return getMinClanMembersNeeded(mode)
+	}
+	if fireteamSize < 1 {
+		return 1
+	}
+	return (fireteamSize + 1) / 2
+}
+
+// extractClanFireteam returns the members of the fireteam that are in the clan.
+//
+// The clan is the current roster: Bungie doesn't provide the roster as of a
+// past week, so a member who has since left the clan isn't counted for their
+// past completions.
+func extractClanFireteam(fireteamMembers []*models.UserUserInfoCard, clanMemberIDs map[int64]bool) []*models.UserUserInfoCard {
+	clanFireteam, _ := partitionFireteam(fireteamMembers, clanMemberIDs)
+	return clanFireteam
+}
+
+// partitionFireteam splits the fireteam into the players that are and aren't
+// clan members, keeping their order.
+func partitionFireteam(fireteamMembers []*models.UserUserInfoCard, clanMemberIDs map[int64]bool) (clan, others []*models.UserUserInfoCard) {
+	for _, fireteamMember := range fireteamMembers {
+		if clanMemberIDs[fireteamMember.MembershipID] {
+			detailLogger.Printf("clan member %v (%q) was a member of the fireteam", fireteamMember.MembershipID, fireteamMember.DisplayName)
+			clan = append(clan, fireteamMember)
+		} else {
+			detailLogger.Printf("fireteam member %v (%q) is not in the current clan roster", fireteamMember.MembershipID, fireteamMember.DisplayName)
+			others = append(others, fireteamMember)
+		}
+	}
+	return clan, others
+}
+
+// reduceFastest returns the completion that took the least time, ignoring nils.
+// Ties are won by the completion that appears first.
+func reduceFastest(candidates ...*completion) *completion {
+	var fastest *completion
+	for _, c := range candidates {
+		if c == nil {
+			continue
+		}
+		if fastest == nil || c.duration < fastest.duration {
+			fastest = c
+		}
+	}
+	return fastest
+}
+
+// reduceEarliest returns the completion that ended first, ignoring nils. Ties
+// are won by the completion that appears first.
+func reduceEarliest(candidates ...*completion) *completion {
+	var earliest *completion
+	for _, c := range candidates {
+		if c == nil {
+			continue
+		}
+		if earliest == nil || c.end.Before(earliest.end) {
+			earliest = c
+		}
+	}
+	return earliest
+}
+
+// modeResult is the result of scanning the clan members' activities for clan
+// completions of a mode.
+type modeResult struct {
+	// seen are the activity instances that have been evaluated. The same
+	// activity is usually in the history of several clan members, so each is
+	// only evaluated once.
+	seen map[int64]bool
+	// earliest is the earliest clan completion.
+	earliest *completion
+	// fastest is the clan completion that took the least time.
+	fastest *completion
+	// count is the number of clan completions. It is only counted with
+	// --count-all.
+	count int
+	// attempts are the clan activities that weren't completed. They are only
+	// collected with --include-incomplete.
+	attempts []*completion
+}
+
+func getEarliestClanCompletion(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, history *activityHistory, clanMemberIDs map[int64]bool, characters []models.DestinyEntitiesCharactersDestinyCharacterComponent, mode ActivityMode, result *modeResult) error {
 	for _, character := range characters {
-		activities, err := getActivities(api, auth, start, end, clanMember, character, mode)
+		// A deleted character can still be listed, and getting its history
+		// fails, so unless --strict is given only the character is skipped.
+		activities, err := history.get(character, mode)
 		if err != nil {
-			return nil, err
+			if flagStrict {
+				return err
+			}
+			logger.Printf("warning: skipping character %v of clan member %v (%q): %v", character.CharacterID, history.user.MembershipID, history.user.DisplayName, err)
+			skipped.addCharacter(history.user, character.CharacterID, err.Error())
+			continue
 		}
 		for _, activity := range activities {
-			c := &completion{
-				end: time.Time(activity.Period).Add(time.Duration(activity.Values["activityDurationSeconds"].Basic.Value) * time.Second),
-			}
-			if activity.Values["completed"].Basic.Value == 0 {
+			if flagActivityHash != 0 && activity.ActivityDetails.ReferenceID != flagActivityHash {
 				continue
 			}
-			var victory bool
-			if standing, ok := activity.Values["standing"]; ok {
-				victory = (standing.Basic.Value == 0)
-			} else if completionReason, ok := activity.Values["completionReason"]; ok {
-				victory = (completionReason.Basic.Value == 0)
-			} else {
-				logger.Panicf("unknown victory state for activity %v", activity.ActivityDetails.InstanceID)
-			}
-			if !victory {
-				continue
-			}
-			if earliest != nil && (c.end.After(earliest.end) || c.end == earliest.end) {
+			if result.seen[activity.ActivityDetails.InstanceID] {
 				continue
 			}
-			fireteamMembers, err := getFireteam(api, auth, activity.ActivityDetails.InstanceID)
-			if err != nil {
-				return nil, err
+			if err := evaluateActivity(api, auth, activity, clanMemberIDs, mode, result); err != nil {
+				return err
 			}
-			for _, fireteamMember := range fireteamMembers {
-				if _, ok := clanMemberIDs[fireteamMember.MembershipID]; ok {
-					logger.Printf("clan member %v (%q) was a member of the fireteam", fireteamMember.MembershipID, fireteamMember.DisplayName)
-					c.fireteamMembers = append(c.fireteamMembers, fireteamMember)
-				}
-			}
-			var minClanMembersNeeded int
-			switch mode {
-			case 4: // Raid
-				minClanMembersNeeded = 3
-			case 16: // NF
-				fallthrough
-			case 39: // Trials
-				fallthrough
-			case 5: // Crucible
-				minClanMembersNeeded = 2
-			default:
-				logger.Panicf("unknown mode: %v", mode)
-			}
-			if len(c.fireteamMembers) < minClanMembersNeeded {
-				logger.Printf("at least half the members were not part of the clan")
-				continue
-			}
-			earliest = c
+			// The instance is only seen once it has been evaluated, so that
+			// one whose PGCR couldn't be fetched is evaluated again through
+			// another clan member.
+			result.seen[activity.ActivityDetails.InstanceID] = true
 		}
 	}
-	return earliest, nil
+	return nil
 }
 
-func getEarliestClanCompletions(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, start, end time.Time, clanMembers []*models.UserUserInfoCard) (*completion, *completion, *completion, *completion, error) {
-	var (
-		raid      *completion
-		nightfall *completion
-		trials    *completion
-		crucible  *completion
-	)
-	// Build a set of the clan member IDs.
-	clanMemberIDs := make(map[int64]bool)
-	for _, clanMember := range clanMembers {
-		clanMemberIDs[clanMember.MembershipID] = true
+// evaluateActivity adds the activity to the result if it's a clan completion
+// (or, with --include-incomplete, a clan attempt).
+func evaluateActivity(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, activity *models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup, clanMemberIDs map[int64]bool, mode ActivityMode, result *modeResult) error {
+	start, duration, ok := getActivityTimes(activity)
+	if !ok {
+		return nil
+	}
+	c := &completion{
+		start:        start,
+		duration:     duration,
+		activityHash: activity.ActivityDetails.ReferenceID,
+	}
+	c.end = c.start.Add(c.duration)
+	// Don't get the PGCR if there weren't enough players for a clan
+	// fireteam.
+	if hasTooFewPlayers(activity, mode) {
+		return nil
 	}
-	for _, clanMember := range clanMembers {
-		characters, err := getCharacters(api, auth, clanMember)
+	if flagIncludeIncomplete && activity.Values["completed"].Basic.Value == 0 {
+		fireteamMembers, fireteamSize, err := getFireteam(api, auth, activity.ActivityDetails.InstanceID, clanMemberIDs, false)
 		if err != nil {
-			return nil, nil, nil, nil, err
+			return err
 		}
-		raid, err = getEarliestClanCompletion(api, auth, start, end, clanMemberIDs, clanMember, characters, 4, raid)
-		if err != nil {
-			return nil, nil, nil, nil, err
+		c.fireteamMembers = extractClanFireteam(fireteamMembers, clanMemberIDs)
+		c.fireteamSize = fireteamSize
+		if len(c.fireteamMembers) >= getClanMembersNeeded(mode, fireteamSize) {
+			result.attempts = append(result.attempts, c)
 		}
-		nightfall, err = getEarliestClanCompletion(api, auth, start, end, clanMemberIDs, clanMember, characters, 16, nightfall)
+		return nil
+	}
+	if !isQualifyingCompletion(activity, mode) {
+		return nil
+	}
+	// Unless all completions are being counted, only completions that
+	// are earlier or faster than the earliest and fastest so far
+	// matter.
+	if !flagCountAll && reduceEarliest(result.earliest, c) != c && reduceFastest(result.fastest, c) != c {
+		return nil
+	}
+	fireteamMembers, fireteamSize, err := getFireteam(api, auth, activity.ActivityDetails.InstanceID, clanMemberIDs, !flagCountPresent)
+	if err != nil {
+		return err
+	}
+	c.fireteamMembers, c.guests = partitionFireteam(fireteamMembers, clanMemberIDs)
+	if !flagShowGuests {
+		c.guests = nil
+	}
+	c.fireteamSize = fireteamSize
+	// The majority is of everyone in the activity, not just those
+	// who completed it.
+	if len(c.fireteamMembers) < getClanMembersNeeded(mode, fireteamSize) {
+		detailLogger.Printf("not enough of the fireteam was part of the clan")
+		return nil
+	}
+	if flagShowIncomplete && !flagCountPresent {
+		everyone, _, err := getFireteam(api, auth, activity.ActivityDetails.InstanceID, clanMemberIDs, false)
 		if err != nil {
-			return nil, nil, nil, nil, err
+			return err
+		}
+		c.incompleteMembers = getIncompleteMembers(extractClanFireteam(everyone, clanMemberIDs), c.fireteamMembers)
+	}
+	if flagCountAll {
+		result.count++
+	}
+	result.earliest = reduceEarliest(result.earliest, c)
+	result.fastest = reduceFastest(result.fastest, c)
+	return nil
+}
+
+// runWeeks scans each of the n weeks with scan, at most concurrency at a time,
+// and passes each to report in order as soon as it and the weeks before it
+// have been scanned. If a scan or report fails, the weeks that haven't started
+// aren't scanned, and the error is returned once the ones in progress finish.
+func runWeeks(n, concurrency int, scan, report func(i int) error) error {
+	errs := make([]error, n)
+	done := make([]chan struct{}, n)
+	sem := make(chan struct{}, concurrency)
+	stop := make(chan struct{})
+	for i := range done {
+		done[i] = make(chan struct{})
+		go func(i int) {
+			defer close(done[i])
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			errs[i] = scan(i)
+		}(i)
+	}
+	for i := range done {
+		<-done[i]
+		err := errs[i]
+		if err == nil {
+			err = report(i)
 		}
-		trials, err = getEarliestClanCompletion(api, auth, start, end, clanMemberIDs, clanMember, characters, 39, trials)
 		if err != nil {
-			return nil, nil, nil, nil, err
+			// Wait for the other weeks rather than leave them scanning
+			// after the report has returned.
+			close(stop)
+			for _, d := range done {
+				<-d
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// hasTooFewPlayers returns whether the activity's player count, when the
+// history includes it, is less than the clan members needed for the mode.
+func hasTooFewPlayers(activity *models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup, mode ActivityMode) bool {
+	// A majority of any number of players can be clan members.
+	if flagClanMajority {
+		return false
+	}
+	playerCount, ok := activity.Values["playerCount"]
+	if !ok || playerCount.Basic == nil {
+		return false
+	}
+	if int(playerCount.Basic.Value) >= getMinClanMembersNeeded(mode) {
+		return false
+	}
+	detailLogger.Printf("skipping instance %v with only %v players", activity.ActivityDetails.InstanceID, playerCount.Basic.Value)
+	return true
+}
+
+// ActivityMode is a Destiny activity mode, as used by the activity history.
+type ActivityMode int32
+
+// The activity modes that are used.
+const (
+	// ModeAll is every mode; it gets the whole activity history.
+	ModeAll        ActivityMode = 0
+	ModeStrikes    ActivityMode = 3
+	ModeRaid       ActivityMode = 4
+	ModeCrucible   ActivityMode = 5
+	ModeNightfall  ActivityMode = 16
+	ModeIronBanner ActivityMode = 19
+	ModeTrials     ActivityMode = 39
+)
+
+func (m ActivityMode) String() string {
+	if m == ModeAll {
+		return "all"
+	}
+	if tracked, ok := findMode(m); ok {
+		return tracked.key
+	}
+	return fmt.Sprintf("mode %d", int32(m))
+}
+
+// trackedMode is an activity mode that can be tracked for clan completions.
+type trackedMode struct {
+	mode ActivityMode
+	key  string
+	name string
+	// pvp is whether the mode's activities are matches that can be lost,
+	// rather than ones that are won by completing them.
+	pvp bool
+}
+
+// allModes are the activity modes that can be tracked for clan completions,
+// in the order they are printed.
+var allModes = []trackedMode{
+	{ModeRaid, "raid", "Raid", false},
+	{ModeNightfall, "nightfall", "Nightfall", false},
+	{ModeTrials, "trials", "Trials", true},
+	{ModeCrucible, "crucible", "Crucible", true},
+	{ModeIronBanner, "ironbanner", "Iron Banner", true},
+	{ModeStrikes, "strikes", "Strikes", false},
+}
+
+// findMode returns the trackable mode, and false if the mode can't be tracked.
+func findMode(mode ActivityMode) (trackedMode, bool) {
+	for _, m := range allModes {
+		if m.mode == mode {
+			return m, true
+		}
+	}
+	return trackedMode{}, false
+}
+
+// modes are the activity modes selected by --modes.
+var modes []trackedMode
+
+// parseModes returns the tracked modes named in the comma-separated list, in
+// the order they are printed.
+func parseModes(list string) ([]trackedMode, error) {
+	keys := make(map[string]bool)
+	for _, key := range strings.Split(list, ",") {
+		keys[strings.ToLower(strings.TrimSpace(key))] = true
+	}
+	var selected []trackedMode
+	for _, m := range allModes {
+		if keys[m.key] {
+			selected = append(selected, m)
+			delete(keys, m.key)
+		}
+	}
+	for key := range keys {
+		return nil, errors.Errorf("unknown mode %q", key)
+	}
+	return selected, nil
+}
+
+// newResults returns empty results for each of the modes.
+func newResults() map[ActivityMode]*modeResult {
+	results := make(map[ActivityMode]*modeResult)
+	for _, m := range modes {
+		results[m.mode] = &modeResult{seen: make(map[int64]bool)}
+	}
+	return results
+}
+
+// isRewardComplete returns whether all of the reward's entries have been
+// earned.
+func isRewardComplete(reward *models.DestinyMilestonesDestinyMilestoneRewardCategory) bool {
+	for _, entry := range reward.Entries {
+		if !entry.Earned {
+			return false
 		}
-		crucible, err = getEarliestClanCompletion(api, auth, start, end, clanMemberIDs, clanMember, characters, 5, crucible)
+	}
+	return len(reward.Entries) > 0
+}
+
+// getEarliestClanCompletions scans the activities of the clan's scanMembers for
+// clan completions, where the fireteam is counted against all of clanMembers.
+// The scan adds to the initial results if there are any, skipping the
+// activities that they have already seen. If the run is interrupted, the results so far
+// are returned with errInterrupted.
+func getEarliestClanCompletions(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, clanID int64, start, end time.Time, clanMembers, scanMembers []*ClanMember, initial map[ActivityMode]*modeResult) (map[ActivityMode]*modeResult, error) {
+	// Resume from the checkpoint if there is one.
+	scan, err := checkpoints.load(clanID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if scan == nil {
+		scan = &scanCheckpoint{
+			DoneMembers: make(map[int64]bool),
+			Results:     initial,
+		}
+		if scan.Results == nil {
+			scan.Results = newResults()
+		}
+	} else if scan.Complete {
+		logger.Printf("skipping the scan of %v to %v, which the checkpoint has complete results for", start, end)
+		return scan.Results, nil
+	} else {
+		logger.Printf("resuming scan of %v to %v from the checkpoint (%v members done)", start, end, len(scan.DoneMembers))
+	}
+	results := scan.Results
+	clanMemberIDs := getClanMemberIDs(clanMembers)
+	scanMember := func(i int, clanMember *ClanMember) error {
+		characters, err := getCharacters(api, auth, clanMember.UserInfo)
 		if err != nil {
-			return nil, nil, nil, nil, err
+			return err
+		}
+		if len(characters) == 0 {
+			// There are no activities to query, so skip the member.
+			skipped.add(clanMember.UserInfo, "no characters (private, new or deleted)")
+			return nil
+		}
+		history := newActivityHistory(api, auth, start, end, clanMember.UserInfo, flagSinglePass)
+		for _, m := range modes {
+			if isInterrupted() {
+				return errInterrupted
+			}
+			progress.Printf("scanning member %v/%v (%v)", i+1, len(scanMembers), m.key)
+			if err := getEarliestClanCompletion(api, auth, history, clanMemberIDs, characters, m.mode, results[m.mode]); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
-	return raid, nightfall, trials, crucible, nil
+	defer progress.Clear()
+	for i, clanMember := range scanMembers {
+		if scan.DoneMembers[clanMember.UserInfo.MembershipID] {
+			continue
+		}
+		if isInterrupted() {
+			return results, errInterrupted
+		}
+		// Unless --strict is given, a member that fails is skipped so that
+		// the rest of the clan can still be reported.
+		if err := scanMember(i, clanMember); errors.Cause(err) == errInterrupted {
+			return results, err
+		} else if err != nil {
+			if flagStrict {
+				return nil, err
+			}
+			logger.Printf("skipping clan member %v (%q): %v", clanMember.UserInfo.MembershipID, clanMember.UserInfo.DisplayName, err)
+			skipped.add(clanMember.UserInfo, err.Error())
+			continue
+		}
+		scan.DoneMembers[clanMember.UserInfo.MembershipID] = true
+		if err := checkpoints.save(clanID, start, end, scan); err != nil {
+			return nil, err
+		}
+	}
+	// A window that hasn't ended can have more completions, and a skipped
+	// member might succeed next time, so then the window is scanned again by
+	// the next run.
+	if skipped.len() == 0 && end.Before(time.Now()) {
+		scan.Complete = true
+		if err := checkpoints.save(clanID, start, end, scan); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// parseSince parses the --since flag, which is either a number of days (e.g.
+// "3" or "3d") or a duration (e.g. "36h").
+func parseSince(since string) (time.Duration, error) {
+	var d time.Duration
+	if days, err := strconv.Atoi(strings.TrimSuffix(since, "d")); err == nil {
+		d = time.Duration(days) * 24 * time.Hour
+	} else if d, err = time.ParseDuration(since); err != nil {
+		return 0, errors.Errorf("invalid --since %q", since)
+	}
+	// An empty or inverted window would silently report nothing.
+	if d <= 0 {
+		return 0, errors.Errorf("invalid --since %q", since)
+	}
+	return d, nil
 }
 
-func main() {
-	flag.Parse()
+// newAPI returns the API client and the authentication to use with it, and
+// whether the authentication includes an OAuth token.
+func newAPI() (*client.BungieNet, runtime.ClientAuthInfoWriter, bool, error) {
+	keys, err := getAPIKeys()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if len(keys) == 0 {
+		return nil, nil, false, errInvalidAPIKey
+	}
+	auth, authenticated, err := newAuth(keys[0], flagOAuthClientID, flagOAuthClientSecret, flagTokenFile)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	api := newAPIClient(keys)
+	if err := validateAPI(api, auth); err != nil {
+		return nil, nil, false, err
+	}
+	return api, auth, authenticated, nil
+}
 
-	if *flagVerbose {
-		logger = log.New(os.Stderr, "", log.LstdFlags)
+func runReport() {
+	if flagPrintSchema {
+		if err := writeReportSchema(os.Stdout); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if flagServe != "" {
+		fatal(serve(flagServe))
+	}
+	if flagWatch {
+		if err := watch(flagInterval); errors.Cause(err) == errInterrupted {
+			if skipped.len() > 0 {
+				skipped.Write(redactWriter(os.Stderr))
+			}
+			fmt.Fprintln(os.Stderr, "the report is partial because it was interrupted")
+			os.Exit(exitCodeInterrupted)
+		} else if err != nil {
+			fatal(err)
+		}
+		return
+	}
+	stopHandlingInterrupts := handleInterrupts()
+	var err error
+	if flagUserFile != "" {
+		err = writeBatchReport(os.Stdout, flagFormat)
 	} else {
-		logger = log.New(ioutil.Discard, "", log.LstdFlags)
+		err = writeReport(os.Stdout, flagFormat)
 	}
+	stopHandlingInterrupts()
+	if flagSummaryJSON {
+		if err := summary.Write(os.Stderr, err); err != nil {
+			logger.Printf("warning: couldn't write the summary: %v", err)
+		}
+	}
+	if errors.Cause(err) == errInterrupted {
+		if skipped.len() > 0 {
+			skipped.Write(redactWriter(os.Stderr))
+		}
+		fmt.Fprintln(os.Stderr, "the report is partial because it was interrupted")
+		os.Exit(exitCodeInterrupted)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if !flagNoFooter && !flagQuiet {
+		fmt.Fprintln(os.Stderr, newMetaReport())
+	}
+	if skipped.len() > 0 {
+		skipped.Write(redactWriter(os.Stderr))
+		if flagFailOnPartial {
+			os.Exit(exitCodePartial)
+		}
+	}
+}
 
-	// Create the API client and authentication.
-	api := client.Default
-	auth := runtime_client.APIKeyAuth("X-API-Key", "header", *flagAPIKey)
+// writeReport writes the report to w in the format.
+func writeReport(w io.Writer, format string) (err error) {
+	if flagConcurrency < 1 {
+		return errors.Errorf("invalid concurrency %v", flagConcurrency)
+	}
+	if flagOnlyEarned && flagOnlyUnearned {
+		return errors.Errorf("--only-earned and --only-unearned are mutually exclusive")
+	}
+	skipped = newSkippedMembers()
+	modes, err = parseModes(flagModes)
+	if err != nil {
+		return err
+	}
+	profileComponents, err = parseProfileComponents(flagProfileComponents)
+	if err != nil {
+		return err
+	}
+	// A batch loads the checkpoint once for all of its clans (see
+	// writeBatchReport).
+	if batchTarget == nil {
+		checkpoints, err = loadCheckpointFile(flagCheckpoint)
+		if err != nil {
+			return err
+		}
+	}
+	loc, err := time.LoadLocation(flagTimezone)
+	if err != nil {
+		return errors.Errorf("invalid --timezone %q: use an IANA time zone name such as America/New_York", flagTimezone)
+	}
+	out, err := newReportWriter(format, w, loc, flagTimeFormat, flagTemplate, flagOutputTemplate)
+	if err != nil {
+		return err
+	}
 
+	// Create the API client and authentication.
+	api, auth, authenticated, err := newAPI()
+	if err != nil {
+		return err
+	}
 	// Open the manifest database.
 	db, err := db.Open(api, auth)
 	if err != nil {
-		logger.Fatal(err)
+		return err
+	}
+	if flagActivityHash != 0 {
+		activityDefinition, err := getActivityDefinition(db, flagActivityHash)
+		if err != nil {
+			return errors.Wrapf(err, "unknown --activity-hash %v", flagActivityHash)
+		}
+		name := fmt.Sprint(flagActivityHash)
+		if activityDefinition.DisplayProperties != nil && activityDefinition.DisplayProperties.Name != "" {
+			name = activityDefinition.DisplayProperties.Name
+		}
+		if tier := getDifficultyTier(activityDefinition); tier != "" {
+			name = fmt.Sprintf("%v (%v)", name, tier)
+		}
+		logger.Printf("only counting completions of %v", name)
 	}
 
-	// Get the clan.
-	clan, err := getClanByDestinyUser(api, auth, *flagUsername)
+	// Get the user and their clan.
+	user, clan, err := getUserAndClan(api, auth)
 	if err != nil {
-		logger.Fatal(err)
+		return err
 	}
+	defer func() {
+		if err == nil {
+			clanSuccesses.record(clan.GroupID)
+		}
+	}()
 
 	// Get the clan rewards.
 	rewards, err := getRewards(api, auth, clan.GroupID)
 	if err != nil {
-		logger.Fatal(err)
+		return err
+	}
+	anchor, err := time.Parse(time.RFC3339, flagResetAnchor)
+	if err != nil {
+		return err
+	}
+	start, end := alignRewardsToReset(api, auth, rewards, anchor, time.Now())
+	if weekStart, weekEnd := getWeek(anchor, start); !weekStart.Equal(start) || !weekEnd.Equal(end) {
+		logger.Printf("warning: reward week %v to %v is not aligned to the reset anchor %v (expected %v to %v)", start, end, anchor, weekStart, weekEnd)
 	}
-	start, end := time.Time(rewards.StartDate), time.Time(rewards.EndDate)
 
 	// Get the clan members.
-	clanMembers, err := getMembers(api, auth, clan.GroupID)
+	clanMembers, partialRoster, err := getCachedMembers(api, auth, clan.GroupID)
 	if err != nil {
-		logger.Fatal(err)
+		return err
 	}
-	sort.Sort(byMembershipID(clanMembers))
-
-	// Print out the reward state.
-	milestoneDefinitionInterface, err := db.Get("DestinyMilestoneDefinition", 4253138191, &models.DestinyDefinitionsMilestonesDestinyMilestoneDefinition{})
+	// The other formats get an empty roster rather than text they can't
+	// parse.
+	if len(clanMembers) == 0 && format == "text" {
+		fmt.Fprintln(w, "clan has no members")
+		return nil
+	} else if len(clanMembers) == 0 {
+		fmt.Fprintln(os.Stderr, "clan has no members")
+		if err := out.WriteRoster(newRosterReport(clan.GroupID, clanMembers)); err != nil {
+			return err
+		}
+		return flushReport(out)
+	}
+	if err := sortMembers(clanMembers, flagSort); err != nil {
+		return err
+	}
+	roster := newRosterReport(clan.GroupID, clanMembers)
+	roster.TriumphScores = getRosterTriumphScores(api, auth, clanMembers)
+	if err := out.WriteRoster(roster); err != nil {
+		return err
+	}
+	// Only scan the members in the members file if there is one.
+	scanMembers := clanMembers
+	if flagMembersFile != "" {
+		entries, err := readMembersFile(flagMembersFile)
+		if err != nil {
+			return err
+		}
+		scanMembers = filterMembers(clanMembers, entries)
+		logger.Printf("scanning %v of %v clan members from the members file", len(scanMembers), len(clanMembers))
+	}
+	// Only scan a sample of the members if requested.
+	sampled := flagMaxMembers > 0 && len(scanMembers) > flagMaxMembers
+	if sampled {
+		scanMembers = scanMembers[:flagMaxMembers]
+		logger.Printf("scanning a sample of %v of %v clan members", len(scanMembers), len(clanMembers))
+	}
+	markSample := func(report *WeekReport) {
+		if sampled {
+			report.Summary.SampledMembers = len(scanMembers)
+			report.Summary.ClanMembers = len(clanMembers)
+		}
+		if partialRoster {
+			report.Summary.PartialRoster = true
+			report.Summary.ClanMembers = len(clanMembers)
+		}
+	}
+	summary.addClan(len(scanMembers))
+	// Only the attribution members count toward a clan fireteam.
+	attributionMembers, err := getAttributionMembers(clanMembers)
 	if err != nil {
-		logger.Fatal(err)
+		return err
+	}
+
+	// Report the whole season if requested.
+	if flagSeason != "" {
+		if user == nil {
+			return errors.Errorf("--season needs --user")
+		}
+		season, err := getSeason(api, auth, db, user, flagSeason)
+		if err != nil {
+			return err
+		}
+		report, err := getSeasonReport(api, auth, clan.GroupID, season, attributionMembers, scanMembers, end)
+		if err != nil && errors.Cause(err) != errInterrupted {
+			return err
+		}
+		report.Summary.Interrupted = errors.Cause(err) == errInterrupted
+		markSample(report)
+		addActivityDetails(db, report)
+		if err := out.WriteWeek(report); err != nil {
+			return err
+		}
+		if err := flushReport(out); err != nil {
+			return err
+		}
+		if report.Summary.Interrupted {
+			return errInterrupted
+		}
+		return nil
 	}
-	milestoneDefinition := milestoneDefinitionInterface.(*models.DestinyDefinitionsMilestonesDestinyMilestoneDefinition)
-	for _, reward := range rewards.Rewards {
-		raid, nightfall, trials, crucible, err := getEarliestClanCompletions(api, auth, start, end, clanMembers)
+
+	// Report the completions in the recent window if requested.
+	if flagSince != "" {
+		since, err := parseSince(flagSince)
 		if err != nil {
-			logger.Fatal(err)
+			return err
 		}
+		end := time.Now().UTC()
+		start := end.Add(-since)
+		results, err := getEarliestClanCompletions(api, auth, clan.GroupID, start, end, attributionMembers, scanMembers, nil)
+		if err != nil && errors.Cause(err) != errInterrupted {
+			return err
+		}
+		report := newWeekReport(clan.GroupID, start, end, results)
+		report.Reward.Name = fmt.Sprintf("Since %v", start)
+		report.Summary.Interrupted = errors.Cause(err) == errInterrupted
+		markSample(report)
+		addActivityDetails(db, report)
+		if err := out.WriteWeek(report); err != nil {
+			return err
+		}
+		if err := flushReport(out); err != nil {
+			return err
+		}
+		if report.Summary.Interrupted {
+			return errInterrupted
+		}
+		return nil
+	}
 
-		rewardCategoryHashStr := strconv.FormatUint(uint64(reward.RewardCategoryHash), 10)
-		rewardCategory := milestoneDefinition.Rewards[rewardCategoryHashStr]
-		fmt.Println(rewardCategory.DisplayProperties.Name)
-		for _, entry := range reward.Entries {
-			earned := " "
-			if entry.Earned {
-				earned = "✓"
+	// Report the reward state.
+	if len(rewards.Rewards) == 0 && format == "text" {
+		fmt.Fprintln(w, "clan has no rewards this week")
+		return nil
+	} else if len(rewards.Rewards) == 0 {
+		fmt.Fprintln(os.Stderr, "clan has no rewards this week")
+		return flushReport(out)
+	}
+	summary.setRewardsEarned(isRewardComplete(rewards.Rewards[0]))
+	milestoneDefinition, err := getMilestoneDefinition(db, rewards)
+	if err != nil {
+		return err
+	}
+	objectives := getRewardObjectives(db, rewards, milestoneDefinition)
+	// Compute the weeks concurrently, but report them in order as soon as
+	// each is ready.
+	type week struct {
+		results map[ActivityMode]*modeResult
+		report  *WeekReport
+		// interrupted is whether the scan was interrupted, so that the
+		// results are only those found so far.
+		interrupted bool
+		// scanned is whether the week was scanned, rather than skipped
+		// because its rewards were all earned.
+		scanned bool
+	}
+	// Only scan the activities since the last run if there's a state file.
+	state, err := loadStateFile(flagStateFile)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = watchState
+	}
+	// A sample's or a partial roster's results would be mistaken for the
+	// whole clan's by the next run.
+	if (sampled || partialRoster) && state != nil {
+		logger.Printf("warning: not keeping state for only part of the clan members")
+		state = nil
+	}
+	// Likewise for the completions of only one activity.
+	if flagActivityHash != 0 && state != nil {
+		logger.Printf("warning: not keeping state for the completions of only one activity")
+		state = nil
+	}
+	runStart := time.Now().UTC()
+	// The rewards are newest first, a week apart.
+	weekStarts := make([]time.Time, len(rewards.Rewards))
+	weekEnds := make([]time.Time, len(rewards.Rewards))
+	for i := range rewards.Rewards {
+		weekStarts[i] = start.Add(-time.Duration(i) * weekPeriod)
+		weekEnds[i] = end.Add(-time.Duration(i) * weekPeriod)
+	}
+	weeks := make([]*week, len(rewards.Rewards))
+	scanWeek := func(i int) error {
+		reward, start, end := rewards.Rewards[i], weekStarts[i], weekEnds[i]
+		wk := &week{}
+		weeks[i] = wk
+		// Don't scan a week whose rewards have all been earned if only the
+		// rewards are wanted.
+		if flagStopWhenComplete && isRewardComplete(reward) && flagTop == 0 && !flagConsolidate && !flagPlatformSummary && !flagStats {
+			wk.results = newResults()
+			wk.report = newWeekReport(clan.GroupID, start, end, wk.results)
+			wk.report.Reward = newRewardCategoryReport(reward, milestoneDefinition, objectives, authenticated)
+			wk.report.Complete = true
+			return nil
+		}
+		scanStart, initial := state.resume(clan.GroupID, start, end)
+		results := initial
+		if scanStart.Before(end) {
+			var err error
+			results, err = getEarliestClanCompletions(api, auth, clan.GroupID, scanStart, end, attributionMembers, scanMembers, initial)
+			if errors.Cause(err) == errInterrupted {
+				wk.interrupted = true
+			} else if err != nil {
+				return err
 			}
-			rewardEntryHashStr := strconv.FormatUint(uint64(entry.RewardEntryHash), 10)
-			name := rewardCategory.RewardEntries[rewardEntryHashStr].DisplayProperties.Name
-			fmt.Printf(" %s %v\n", earned, name)
 		}
-		if raid != nil {
-			fmt.Printf("Raid      completed at %v by %v\n", raid.end, raid.getFireteamAsString())
+		wk.scanned = true
+		wk.results = results
+		wk.report = newWeekReport(clan.GroupID, start, end, results)
+		wk.report.Summary.Interrupted = wk.interrupted
+		addActivityDetails(db, wk.report)
+		wk.report.Reward = newRewardCategoryReport(reward, milestoneDefinition, objectives, authenticated)
+		if flagLateJoiners {
+			wk.report.JoinedAfterStart = getJoinedAfter(clanMembers, start)
+		}
+		if flagPlatformSummary {
+			wk.report.Platforms = getPlatformSummary(results)
 		}
-		if nightfall != nil {
-			fmt.Printf("Nightfall completed at %v by %v\n", nightfall.end, nightfall.getFireteamAsString())
+		return nil
+	}
+	contributions := make(map[int64]*ContributorReport)
+	wasInterrupted := false
+	reportWeek := func(i int) error {
+		wk := weeks[i]
+		wasInterrupted = wasInterrupted || wk.interrupted
+		wk.report.NewlyEarned = state.updateRewards(wk.report)
+		markSample(wk.report)
+		if !flagNoWeeks {
+			if err := out.WriteWeek(wk.report); err != nil {
+				return err
+			}
 		}
-		if trials != nil {
-			fmt.Printf("Trials    completed at %v by %v\n", trials.end, trials.getFireteamAsString())
+		addContributions(contributions, wk.results)
+		return nil
+	}
+	if err := runWeeks(len(weeks), flagConcurrency, scanWeek, reportWeek); err != nil {
+		return err
+	}
+	// The results of an interrupted scan are reported, but aren't kept as
+	// if the weeks had been scanned. Nor are the results of a scan that
+	// skipped members, which are missing their completions, so the clan's
+	// state is left for the next run to resume from instead.
+	if !wasInterrupted {
+		if skipped.len() == 0 {
+			for i, wk := range weeks {
+				if wk.scanned {
+					state.record(clan.GroupID, weekStarts[i], wk.results)
+				}
+			}
+			state.finish(clan.GroupID, runStart, weekStarts)
+		} else if state != nil {
+			logger.Printf("warning: not keeping the clan's scan state since %v clan members were skipped", skipped.len())
 		}
-		if crucible != nil {
-			fmt.Printf("Crucible  completed at %v by %v\n", crucible.end, crucible.getFireteamAsString())
+		if err := state.save(flagStateFile); err != nil {
+			return err
 		}
-		fmt.Println()
+	}
 
-		start = start.AddDate(0, 0, -7)
-		end = end.AddDate(0, 0, -7)
+	// Report the top contributors if requested.
+	if flagTop > 0 {
+		if err := out.WriteContributors(newContributorsReport(clan.GroupID, contributions, flagTop)); err != nil {
+			return err
+		}
+	}
+	// Report the leaderboard of all of the weeks if requested.
+	if flagConsolidate {
+		report := newContributorsReport(clan.GroupID, contributions, len(contributions))
+		report.Consolidated = true
+		if err := out.WriteContributors(report); err != nil {
+			return err
+		}
+	}
+	if err := flushReport(out); err != nil {
+		return err
+	}
+	if wasInterrupted {
+		return errInterrupted
 	}
+	return nil
 }
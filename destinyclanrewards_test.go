@@ -0,0 +1,952 @@
+package main
+
+import (
+	"math"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/pkg/errors"
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+func TestMain(m *testing.M) {
+	setUpLoggers(0)
+	os.Exit(m.Run())
+}
+
+// newValues returns activity stats with the basic values.
+func newValues(values map[string]float64) map[string]models.DestinyHistoricalStatsDestinyHistoricalStatsValue {
+	m := make(map[string]models.DestinyHistoricalStatsDestinyHistoricalStatsValue)
+	for name, value := range values {
+		m[name] = models.DestinyHistoricalStatsDestinyHistoricalStatsValue{
+			StatID: name,
+			Basic:  &models.DestinyHistoricalStatsDestinyHistoricalStatsValuePair{Value: value},
+		}
+	}
+	return m
+}
+
+// newUsers returns users with the membership IDs.
+func newUsers(ids ...int64) []*models.UserUserInfoCard {
+	var users []*models.UserUserInfoCard
+	for _, id := range ids {
+		users = append(users, &models.UserUserInfoCard{MembershipID: id})
+	}
+	return users
+}
+
+func getMembershipIDs(users []*models.UserUserInfoCard) []int64 {
+	var ids []int64
+	for _, user := range users {
+		ids = append(ids, user.MembershipID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIsVictory(t *testing.T) {
+	tests := []struct {
+		name        string
+		values      map[string]float64
+		wantVictory bool
+		wantOK      bool
+	}{
+		{"standing won", map[string]float64{"standing": 0}, true, true},
+		{"standing lost", map[string]float64{"standing": 1}, false, true},
+		{"standing wins over completion reason", map[string]float64{"standing": 1, "completionReason": 0}, false, true},
+		{"completion reason won", map[string]float64{"completionReason": 0}, true, true},
+		{"completion reason lost", map[string]float64{"completionReason": 2}, false, true},
+		{"neither", map[string]float64{"completed": 1}, false, false},
+	}
+	for _, tt := range tests {
+		victory, ok := isVictory(newValues(tt.values))
+		if victory != tt.wantVictory || ok != tt.wantOK {
+			t.Errorf("%v: isVictory() = %v, %v; want %v, %v", tt.name, victory, ok, tt.wantVictory, tt.wantOK)
+		}
+	}
+}
+
+func TestExtractClanFireteam(t *testing.T) {
+	clanMemberIDs := map[int64]bool{1: true, 2: true, 3: true}
+	tests := []struct {
+		name       string
+		fireteam   []int64
+		wantClan   []int64
+		wantOthers []int64
+	}{
+		{"empty", nil, nil, nil},
+		{"all clan", []int64{1, 2, 3}, []int64{1, 2, 3}, nil},
+		{"no clan", []int64{4, 5}, nil, []int64{4, 5}},
+		{"mixed keeps order", []int64{4, 2, 5, 1, 6}, []int64{2, 1}, []int64{4, 5, 6}},
+	}
+	for _, tt := range tests {
+		fireteam := newUsers(tt.fireteam...)
+		if got := getMembershipIDs(extractClanFireteam(fireteam, clanMemberIDs)); !equalIDs(got, tt.wantClan) {
+			t.Errorf("%v: extractClanFireteam() = %v; want %v", tt.name, got, tt.wantClan)
+		}
+		clan, others := partitionFireteam(fireteam, clanMemberIDs)
+		gotClan, gotOthers := getMembershipIDs(clan), getMembershipIDs(others)
+		if !equalIDs(gotClan, tt.wantClan) || !equalIDs(gotOthers, tt.wantOthers) {
+			t.Errorf("%v: partitionFireteam() = %v, %v; want %v, %v", tt.name, gotClan, gotOthers, tt.wantClan, tt.wantOthers)
+		}
+	}
+}
+
+func TestReduceEarliestAndFastest(t *testing.T) {
+	base := time.Date(2020, time.March, 10, 17, 0, 0, 0, time.UTC)
+	newCompletion := func(startMinutes, durationMinutes int) *completion {
+		c := &completion{
+			start:    base.Add(time.Duration(startMinutes) * time.Minute),
+			duration: time.Duration(durationMinutes) * time.Minute,
+		}
+		c.end = c.start.Add(c.duration)
+		return c
+	}
+	long := newCompletion(0, 60)       // ends at 60
+	short := newCompletion(30, 10)     // ends at 40
+	late := newCompletion(120, 5)      // ends at 125
+	sameEnd := newCompletion(20, 20)   // ends at 40, like short
+	sameLength := newCompletion(50, 5) // as fast as late
+	tests := []struct {
+		name         string
+		candidates   []*completion
+		wantEarliest *completion
+		wantFastest  *completion
+	}{
+		{"none", nil, nil, nil},
+		{"only nils", []*completion{nil, nil}, nil, nil},
+		{"one", []*completion{long}, long, long},
+		{"nil first", []*completion{nil, long}, long, long},
+		{"earliest isn't fastest", []*completion{long, short, late}, short, late},
+		{"tie for earliest goes to the first", []*completion{short, sameEnd}, short, short},
+		{"tie for fastest goes to the first", []*completion{late, sameLength}, sameLength, late},
+	}
+	for _, tt := range tests {
+		if got := reduceEarliest(tt.candidates...); got != tt.wantEarliest {
+			t.Errorf("%v: reduceEarliest() = %v; want %v", tt.name, got, tt.wantEarliest)
+		}
+		if got := reduceFastest(tt.candidates...); got != tt.wantFastest {
+			t.Errorf("%v: reduceFastest() = %v; want %v", tt.name, got, tt.wantFastest)
+		}
+	}
+}
+
+func TestNoDestinyUserError(t *testing.T) {
+	tests := []struct {
+		name        string
+		suggestions []string
+		want        string
+	}{
+		{"no suggestions", nil, `no destiny player found for "Name#1234"; try the Bungie Name form (Name#1234)`},
+		{"suggestions", []string{"Name#1235", "Names#0001"}, `no exact match for "Name#1234"; did you mean Name#1235, Names#0001?`},
+	}
+	for _, tt := range tests {
+		if got := noDestinyUserError("Name#1234", tt.suggestions).Error(); got != tt.want {
+			t.Errorf("%v: noDestinyUserError() = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// fakePlayerFinder is a PlayerFinder with canned results.
+type fakePlayerFinder struct {
+	found       []*models.UserUserInfoCard
+	err         error
+	suggestions []string
+	// characters are the number of characters of each membership ID.
+	characters map[int64]int
+}
+
+func (f *fakePlayerFinder) Search(name string) ([]*models.UserUserInfoCard, error) {
+	return f.found, f.err
+}
+
+func (f *fakePlayerFinder) Suggest(username string) []string {
+	return f.suggestions
+}
+
+func (f *fakePlayerFinder) Characters(user *models.UserUserInfoCard) ([]models.DestinyEntitiesCharactersDestinyCharacterComponent, error) {
+	return make([]models.DestinyEntitiesCharactersDestinyCharacterComponent, f.characters[user.MembershipID]), nil
+}
+
+func TestFindDestinyUser(t *testing.T) {
+	xbox := &models.UserUserInfoCard{MembershipID: 1, MembershipType: 1}
+	psn := &models.UserUserInfoCard{MembershipID: 2, MembershipType: 2}
+	steam := &models.UserUserInfoCard{MembershipID: 3, MembershipType: 3}
+	otherSteam := &models.UserUserInfoCard{MembershipID: 4, MembershipType: 3}
+	crossSaved := func(id, membershipType int64) *models.UserUserInfoCard {
+		return &models.UserUserInfoCard{MembershipID: id, MembershipType: membershipType, CrossSaveOverride: 3, BungieGlobalDisplayName: "Name", BungieGlobalDisplayNameCode: 1234}
+	}
+	primary := crossSaved(3, 3)
+	errSearch := errors.New("search failed")
+	tests := []struct {
+		name       string
+		preference string
+		finder     *fakePlayerFinder
+		want       *models.UserUserInfoCard
+		wantErr    string
+	}{
+		{
+			name:    "zero",
+			finder:  &fakePlayerFinder{},
+			wantErr: `no destiny player found for "Name"; try the Bungie Name form (Name#1234)`,
+		},
+		{
+			name:    "zero, with suggestions",
+			finder:  &fakePlayerFinder{suggestions: []string{"Name#1235"}},
+			wantErr: `no exact match for "Name"; did you mean Name#1235?`,
+		},
+		{
+			name:    "search fails",
+			finder:  &fakePlayerFinder{err: errSearch},
+			wantErr: errSearch.Error(),
+		},
+		{
+			name:   "one",
+			finder: &fakePlayerFinder{found: []*models.UserUserInfoCard{psn}},
+			want:   psn,
+		},
+		{
+			name:   "one cross saved on three platforms",
+			finder: &fakePlayerFinder{found: []*models.UserUserInfoCard{crossSaved(1, 1), crossSaved(2, 2), primary}},
+			want:   primary,
+		},
+		{
+			name:       "many, preferred platform",
+			preference: "steam",
+			finder:     &fakePlayerFinder{found: []*models.UserUserInfoCard{xbox, psn, steam}},
+			want:       steam,
+		},
+		{
+			name:       "many, first preferred platform found",
+			preference: "steam,psn,xbox",
+			finder:     &fakePlayerFinder{found: []*models.UserUserInfoCard{xbox, psn}},
+			want:       psn,
+		},
+		{
+			name:       "many, two on the preferred platform",
+			preference: "steam,xbox",
+			finder:     &fakePlayerFinder{found: []*models.UserUserInfoCard{steam, otherSteam, xbox}},
+			want:       xbox,
+		},
+		{
+			name:       "many, only one has characters",
+			preference: "steam",
+			finder:     &fakePlayerFinder{found: []*models.UserUserInfoCard{xbox, psn}, characters: map[int64]int{2: 3}},
+			want:       psn,
+		},
+		{
+			name:       "many, all have characters",
+			preference: "steam",
+			finder:     &fakePlayerFinder{found: []*models.UserUserInfoCard{xbox, psn}, characters: map[int64]int{1: 1, 2: 3}},
+			wantErr:    `found 2 destiny users named "Name" (Xbox 1, PSN 2); pick one with --platform-preference or --membershipid and --membershiptype`,
+		},
+	}
+	defer func(preference string) { flagPlatformPreference = preference }(flagPlatformPreference)
+	for _, tt := range tests {
+		flagPlatformPreference = tt.preference
+		got, err := findDestinyUser(tt.finder, "Name")
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%v: findDestinyUser() failed with %v; want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: findDestinyUser() failed: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%v: findDestinyUser() = %v; want %v", tt.name, got.MembershipID, tt.want.MembershipID)
+		}
+	}
+}
+
+// newPGCR returns a post game carnage report with an entry for each player,
+// who completed the activity unless they're in incomplete.
+func newPGCR(players []int64, incomplete ...int64) *models.DestinyHistoricalStatsDestinyPostGameCarnageReportData {
+	didntComplete := make(map[int64]bool)
+	for _, id := range incomplete {
+		didntComplete[id] = true
+	}
+	pgcr := &models.DestinyHistoricalStatsDestinyPostGameCarnageReportData{}
+	for _, id := range players {
+		completed := 1.0
+		if didntComplete[id] {
+			completed = 0
+		}
+		pgcr.Entries = append(pgcr.Entries, &models.DestinyHistoricalStatsDestinyPostGameCarnageReportEntry{
+			Player: &models.DestinyHistoricalStatsDestinyPlayer{DestinyUserInfo: &models.UserUserInfoCard{MembershipID: id}},
+			Values: newValues(map[string]float64{"completed": completed}),
+		})
+	}
+	return pgcr
+}
+
+// setTeams sets the team of each player in the post game carnage report.
+func setTeams(pgcr *models.DestinyHistoricalStatsDestinyPostGameCarnageReportData, teams map[int64]float64) *models.DestinyHistoricalStatsDestinyPostGameCarnageReportData {
+	for _, entry := range pgcr.Entries {
+		entry.Values["team"] = models.DestinyHistoricalStatsDestinyHistoricalStatsValue{
+			StatID: "team",
+			Basic:  &models.DestinyHistoricalStatsDestinyHistoricalStatsValuePair{Value: teams[entry.Player.DestinyUserInfo.MembershipID]},
+		}
+	}
+	return pgcr
+}
+
+// newMatch returns the post game carnage report of a 6v6 match between
+// players 1 to 6 on alpha team and players 7 to 12 on bravo team.
+func newMatch() *models.DestinyHistoricalStatsDestinyPostGameCarnageReportData {
+	const alpha, bravo = 17, 18
+	teams := make(map[int64]float64)
+	var players []int64
+	for id := int64(1); id <= 12; id++ {
+		players = append(players, id)
+		teams[id] = alpha
+		if id > 6 {
+			teams[id] = bravo
+		}
+	}
+	return setTeams(newPGCR(players), teams)
+}
+
+func TestGetPGCRFireteamCountPresent(t *testing.T) {
+	// Clan members 1, 2 and 3 raided with 4, 5 and 6, and 3 disconnected
+	// before the end.
+	pgcr := newPGCR([]int64{1, 2, 3, 4, 5, 6}, 3)
+	clanMemberIDs := map[int64]bool{1: true, 2: true, 3: true}
+	tests := []struct {
+		name          string
+		completedOnly bool
+		want          []int64
+		wantCounts    bool
+	}{
+		{"completed only", true, []int64{1, 2}, false},
+		{"everyone present", false, []int64{1, 2, 3}, true},
+	}
+	for _, tt := range tests {
+		clanFireteam := extractClanFireteam(getPGCRFireteam(pgcr, tt.completedOnly), clanMemberIDs)
+		if got := getMembershipIDs(clanFireteam); !equalIDs(got, tt.want) {
+			t.Errorf("%v: clan fireteam = %v; want %v", tt.name, got, tt.want)
+		}
+		if counts := len(clanFireteam) >= getClanMembersNeeded(ModeRaid, len(pgcr.Entries)); counts != tt.wantCounts {
+			t.Errorf("%v: counts = %v; want %v", tt.name, counts, tt.wantCounts)
+		}
+	}
+}
+
+func TestRunWeeks(t *testing.T) {
+	errScan := errors.New("scan failed")
+	errReport := errors.New("report failed")
+	tests := []struct {
+		name        string
+		weeks       int
+		concurrency int
+		// failScan and failReport are the weeks whose scan or report
+		// fails, or -1.
+		failScan   int
+		failReport int
+		wantErr    error
+		// wantReported is the number of weeks reported, in order.
+		wantReported int
+	}{
+		{"no weeks", 0, 4, -1, -1, nil, 0},
+		{"one week", 1, 4, -1, -1, nil, 1},
+		{"serial", 8, 1, -1, -1, nil, 8},
+		{"concurrent", 8, 4, -1, -1, nil, 8},
+		{"all at once", 8, 8, -1, -1, nil, 8},
+		{"scan fails serially", 8, 1, 3, -1, errScan, 3},
+		{"scan fails concurrently", 8, 4, 3, -1, errScan, 3},
+		{"report fails concurrently", 8, 4, -1, 2, errReport, 2},
+	}
+	for _, tt := range tests {
+		// Each week's result depends only on the week, and the later
+		// weeks finish first, so the concurrent results are only the same
+		// as the serial ones if they're reported in order.
+		results := make([]int, tt.weeks)
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		scan := func(i int) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			defer func() {
+				mu.Lock()
+				running--
+				mu.Unlock()
+			}()
+			time.Sleep(time.Duration(tt.weeks-i) * time.Millisecond)
+			if i == tt.failScan {
+				return errScan
+			}
+			results[i] = i * i
+			return nil
+		}
+		var reported []int
+		report := func(i int) error {
+			if i == tt.failReport {
+				return errReport
+			}
+			reported = append(reported, results[i])
+			return nil
+		}
+		err := runWeeks(tt.weeks, tt.concurrency, scan, report)
+		if err != tt.wantErr {
+			t.Errorf("%v: runWeeks() = %v; want %v", tt.name, err, tt.wantErr)
+		}
+		if len(reported) != tt.wantReported {
+			t.Errorf("%v: reported %v weeks; want %v", tt.name, len(reported), tt.wantReported)
+		}
+		for i, result := range reported {
+			if result != i*i {
+				t.Errorf("%v: week %v reported %v; want %v", tt.name, i, result, i*i)
+			}
+		}
+		mu.Lock()
+		if running != 0 {
+			t.Errorf("%v: %v weeks still scanning after runWeeks returned", tt.name, running)
+		}
+		if maxRunning > tt.concurrency {
+			t.Errorf("%v: %v weeks scanned at once; want at most %v", tt.name, maxRunning, tt.concurrency)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestCollectMembers(t *testing.T) {
+	// newPage returns a page of members with the membership IDs.
+	newPage := func(hasMore bool, ids ...int64) *models.SearchResultOfGroupMember {
+		page := &models.SearchResultOfGroupMember{HasMore: hasMore, TotalResults: 5}
+		for _, user := range newUsers(ids...) {
+			page.Results = append(page.Results, &models.GroupsV2GroupMember{DestinyUserInfo: user})
+		}
+		return page
+	}
+	errPage := errors.New("page failed")
+	tests := []struct {
+		name        string
+		pages       map[int32]*models.SearchResultOfGroupMember
+		startPage   int
+		maxPages    int
+		want        []int64
+		wantPages   []int32
+		wantPartial bool
+		wantErr     bool
+	}{
+		{
+			name:      "one page",
+			pages:     map[int32]*models.SearchResultOfGroupMember{1: newPage(false, 1, 2)},
+			startPage: 1, maxPages: maxMemberPages,
+			want: []int64{1, 2}, wantPages: []int32{1},
+		},
+		{
+			name: "many pages",
+			pages: map[int32]*models.SearchResultOfGroupMember{
+				1: newPage(true, 1, 2),
+				2: newPage(true, 3, 4),
+				3: newPage(false, 5),
+			},
+			startPage: 1, maxPages: maxMemberPages,
+			want: []int64{1, 2, 3, 4, 5}, wantPages: []int32{1, 2, 3},
+		},
+		{
+			name: "start page",
+			pages: map[int32]*models.SearchResultOfGroupMember{
+				2: newPage(true, 3, 4),
+				3: newPage(false, 5),
+			},
+			startPage: 2, maxPages: maxMemberPages,
+			want: []int64{3, 4, 5}, wantPages: []int32{2, 3}, wantPartial: true,
+		},
+		{
+			name: "always has more",
+			pages: map[int32]*models.SearchResultOfGroupMember{
+				1: newPage(true, 1),
+				2: newPage(true, 2),
+				3: newPage(true, 3),
+				4: newPage(true, 4),
+			},
+			startPage: 1, maxPages: 3,
+			want: []int64{1, 2, 3}, wantPages: []int32{1, 2, 3}, wantPartial: true,
+		},
+		{
+			name: "empty page with more",
+			pages: map[int32]*models.SearchResultOfGroupMember{
+				1: newPage(true, 1, 2),
+				2: newPage(true),
+			},
+			startPage: 1, maxPages: maxMemberPages,
+			want: []int64{1, 2}, wantPages: []int32{1, 2}, wantPartial: true,
+		},
+		{
+			name:      "page fails",
+			pages:     map[int32]*models.SearchResultOfGroupMember{1: newPage(true, 1, 2)},
+			startPage: 1, maxPages: maxMemberPages,
+			wantPages: []int32{1, 2}, wantErr: true,
+		},
+		{
+			name:      "invalid start page",
+			startPage: 0, maxPages: maxMemberPages,
+			wantErr: true,
+		},
+	}
+	defer func(startPage, maxPages int) {
+		flagMembersStartPage, flagMembersMaxPages = startPage, maxPages
+	}(flagMembersStartPage, flagMembersMaxPages)
+	for _, tt := range tests {
+		flagMembersStartPage, flagMembersMaxPages = tt.startPage, tt.maxPages
+		var fetched []int32
+		members, partial, err := collectMembers(func(page int32) (*models.SearchResultOfGroupMember, error) {
+			fetched = append(fetched, page)
+			if response, ok := tt.pages[page]; ok {
+				return response, nil
+			}
+			return nil, errPage
+		})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: collectMembers() failed: %v; want error %v", tt.name, err, tt.wantErr)
+		}
+		var got []int64
+		for _, member := range members {
+			got = append(got, member.UserInfo.MembershipID)
+		}
+		if !equalIDs(got, tt.want) {
+			t.Errorf("%v: collectMembers() = %v; want %v", tt.name, got, tt.want)
+		}
+		if partial != tt.wantPartial {
+			t.Errorf("%v: collectMembers() partial = %v; want %v", tt.name, partial, tt.wantPartial)
+		}
+		if len(fetched) != len(tt.wantPages) {
+			t.Errorf("%v: fetched pages %v; want %v", tt.name, fetched, tt.wantPages)
+			continue
+		}
+		for i := range fetched {
+			if fetched[i] != tt.wantPages[i] {
+				t.Errorf("%v: fetched pages %v; want %v", tt.name, fetched, tt.wantPages)
+				break
+			}
+		}
+	}
+}
+
+func TestGetProfileCharacters(t *testing.T) {
+	newProfile := func(data map[string]models.DestinyEntitiesCharactersDestinyCharacterComponent) *models.DestinyResponsesDestinyProfileResponse {
+		return &models.DestinyResponsesDestinyProfileResponse{
+			Characters: &models.DictionaryComponentResponseOfint64AndDestinyCharacterComponent{Data: data},
+		}
+	}
+	tests := []struct {
+		name    string
+		profile *models.DestinyResponsesDestinyProfileResponse
+		want    int
+	}{
+		{"no profile", nil, 0},
+		{"no characters component", &models.DestinyResponsesDestinyProfileResponse{}, 0},
+		{"nil character map", newProfile(nil), 0},
+		{"empty character map", newProfile(map[string]models.DestinyEntitiesCharactersDestinyCharacterComponent{}), 0},
+		{"characters", newProfile(map[string]models.DestinyEntitiesCharactersDestinyCharacterComponent{
+			"1": {CharacterID: 1},
+			"2": {CharacterID: 2},
+		}), 2},
+	}
+	user := &models.UserUserInfoCard{MembershipID: 1, DisplayName: "Name"}
+	for _, tt := range tests {
+		if got := getProfileCharacters(user, tt.profile); len(got) != tt.want {
+			t.Errorf("%v: getProfileCharacters() returned %v characters; want %v", tt.name, len(got), tt.want)
+		}
+	}
+}
+
+// newActivity returns an activity history entry for the instance that started
+// at start and took seconds.
+func newActivity(instanceID int64, start time.Time, seconds float64) *models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup {
+	return &models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup{
+		Period:          strfmt.DateTime(start),
+		ActivityDetails: &models.DestinyHistoricalStatsDestinyHistoricalStatsActivity{InstanceID: instanceID},
+		Values:          newValues(map[string]float64{"activityDurationSeconds": seconds}),
+	}
+}
+
+func getInstanceIDs(activities []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup) []int64 {
+	var ids []int64
+	for _, activity := range activities {
+		ids = append(ids, activity.ActivityDetails.InstanceID)
+	}
+	return ids
+}
+
+func TestFilterActivityWindow(t *testing.T) {
+	start := time.Date(2020, time.March, 10, 17, 0, 0, 0, time.UTC)
+	end := start.Add(weekPeriod)
+	hour := float64(time.Hour / time.Second)
+	tests := []struct {
+		name         string
+		activities   []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup
+		want         []int64
+		wantPredates bool
+	}{
+		{"none", nil, nil, false},
+		{"inside", []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup{
+			newActivity(2, start.Add(2*time.Hour), hour),
+			newActivity(1, start.Add(time.Hour), hour),
+		}, []int64{2, 1}, false},
+		{"ends at the start", []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup{
+			newActivity(1, start.Add(-time.Hour), hour),
+		}, []int64{1}, false},
+		{"starts before and ends after the start", []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup{
+			newActivity(1, start.Add(-time.Hour), 2*hour),
+		}, []int64{1}, false},
+		{"ends at the end", []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup{
+			newActivity(1, end.Add(-time.Hour), hour),
+		}, []int64{1}, false},
+		{"ends after the end", []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup{
+			newActivity(2, end.Add(-time.Hour), 2*hour),
+			newActivity(1, start.Add(time.Hour), hour),
+		}, []int64{1}, false},
+		{"stops at the first before the window", []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup{
+			newActivity(3, start.Add(time.Hour), hour),
+			newActivity(2, start.Add(-2*time.Hour), hour),
+			newActivity(1, start.Add(-time.Hour), hour),
+		}, []int64{3}, true},
+		{"skips invalid activities", []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup{
+			newActivity(2, start.Add(time.Hour), -1),
+			newActivity(1, time.Time{}, hour),
+		}, nil, false},
+	}
+	for _, tt := range tests {
+		inWindow, predates := filterActivityWindow(tt.activities, start, end)
+		if got := getInstanceIDs(inWindow); !equalIDs(got, tt.want) || predates != tt.wantPredates {
+			t.Errorf("%v: filterActivityWindow() = %v, %v; want %v, %v", tt.name, got, predates, tt.want, tt.wantPredates)
+		}
+	}
+}
+
+func TestGetActivityTimes(t *testing.T) {
+	start := time.Date(2020, time.March, 10, 17, 0, 0, 0, time.UTC)
+	noBasic := newActivity(1, start, 0)
+	noBasic.Values["activityDurationSeconds"] = models.DestinyHistoricalStatsDestinyHistoricalStatsValue{}
+	noDuration := newActivity(1, start, 0)
+	delete(noDuration.Values, "activityDurationSeconds")
+	tests := []struct {
+		name         string
+		activity     *models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup
+		wantDuration time.Duration
+		wantOK       bool
+	}{
+		{"valid", newActivity(1, start, 90), 90 * time.Second, true},
+		{"zero duration", newActivity(1, start, 0), 0, true},
+		{"fractional seconds", newActivity(1, start, 90.9), 90 * time.Second, true},
+		{"zero period", newActivity(1, time.Time{}, 90), 0, false},
+		{"no duration", noDuration, 0, false},
+		{"no basic value", noBasic, 0, false},
+		{"NaN", newActivity(1, start, math.NaN()), 0, false},
+		{"negative", newActivity(1, start, -1), 0, false},
+		{"infinite", newActivity(1, start, math.Inf(1)), 0, false},
+		{"overflows a duration", newActivity(1, start, float64(math.MaxInt64/int64(time.Second))+1000), 0, false},
+		{"large float", newActivity(1, start, 1e300), 0, false},
+	}
+	for _, tt := range tests {
+		gotStart, duration, ok := getActivityTimes(tt.activity)
+		if ok != tt.wantOK || duration != tt.wantDuration {
+			t.Errorf("%v: getActivityTimes() = %v, %v; want %v, %v", tt.name, duration, ok, tt.wantDuration, tt.wantOK)
+		}
+		if ok && !gotStart.Equal(start) {
+			t.Errorf("%v: getActivityTimes() started at %v; want %v", tt.name, gotStart, start)
+		}
+	}
+}
+
+func TestGetClanMembersNeeded(t *testing.T) {
+	tests := []struct {
+		name            string
+		mode            ActivityMode
+		fireteamSize    int
+		clanMajority    bool
+		noFireteamCheck bool
+		want            int
+	}{
+		{"raid, fixed", ModeRaid, 6, false, false, 3},
+		{"raid, fixed, small fireteam", ModeRaid, 3, false, false, 3},
+		{"raid, majority", ModeRaid, 6, true, false, 3},
+		{"raid, majority, odd fireteam", ModeRaid, 5, true, false, 3},
+		{"raid, majority, small fireteam", ModeRaid, 2, true, false, 1},
+		{"raid, majority, no fireteam", ModeRaid, 0, true, false, 1},
+		{"nightfall, fixed", ModeNightfall, 3, false, false, 2},
+		{"nightfall, majority", ModeNightfall, 3, true, false, 2},
+		{"trials, fixed", ModeTrials, 3, false, false, 2},
+		{"crucible, majority", ModeCrucible, 6, true, false, 3},
+		{"no fireteam check", ModeRaid, 6, false, true, 1},
+		{"no fireteam check wins over majority", ModeRaid, 6, true, true, 1},
+	}
+	defer func(clanMajority, noFireteamCheck bool) {
+		flagClanMajority, flagNoFireteamCheck = clanMajority, noFireteamCheck
+	}(flagClanMajority, flagNoFireteamCheck)
+	for _, tt := range tests {
+		flagClanMajority, flagNoFireteamCheck = tt.clanMajority, tt.noFireteamCheck
+		if got := getClanMembersNeeded(tt.mode, tt.fireteamSize); got != tt.want {
+			t.Errorf("%v: getClanMembersNeeded(%v, %v) = %v; want %v", tt.name, tt.mode, tt.fireteamSize, got, tt.want)
+		}
+	}
+}
+
+func TestRaidClanCounts(t *testing.T) {
+	// A 6 person raid with clan members 1 up to 6.
+	players := []int64{1, 2, 3, 4, 5, 6}
+	tests := []struct {
+		clanMembers  int
+		clanMajority bool
+		wantCounts   bool
+	}{
+		{0, false, false},
+		{2, false, false},
+		{3, false, true},
+		{6, false, true},
+		{2, true, false},
+		{3, true, true},
+		{4, true, true},
+	}
+	defer func(clanMajority bool) { flagClanMajority = clanMajority }(flagClanMajority)
+	for _, tt := range tests {
+		flagClanMajority = tt.clanMajority
+		clanMemberIDs := make(map[int64]bool)
+		for _, id := range players[:tt.clanMembers] {
+			clanMemberIDs[id] = true
+		}
+		clanFireteam := extractClanFireteam(getPGCRFireteam(newPGCR(players), true), clanMemberIDs)
+		if counts := len(clanFireteam) >= getClanMembersNeeded(ModeRaid, len(players)); counts != tt.wantCounts {
+			t.Errorf("%v clan members, majority %v: counts = %v; want %v", tt.clanMembers, tt.clanMajority, counts, tt.wantCounts)
+		}
+	}
+}
+
+func TestGetEarliestClanCompletionCharacterFails(t *testing.T) {
+	start := time.Date(2020, time.March, 10, 17, 0, 0, 0, time.UTC)
+	// newSoloActivity returns a solo raid, which is too small for a clan
+	// fireteam, so evaluating it doesn't get its PGCR.
+	newSoloActivity := func(instanceID int64) *models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup {
+		activity := newActivity(instanceID, start, 60)
+		activity.Values = newValues(map[string]float64{"activityDurationSeconds": 60, "completed": 1, "playerCount": 1})
+		return activity
+	}
+	characters := []models.DestinyEntitiesCharactersDestinyCharacterComponent{{CharacterID: 1}, {CharacterID: 2}, {CharacterID: 3}}
+	errHistory := errors.New("history failed")
+	tests := []struct {
+		name        string
+		strict      bool
+		singlePass  bool
+		wantErr     error
+		wantSeen    []int64
+		wantSkipped int
+	}{
+		{"skips the character", false, false, nil, []int64{10, 30}, 1},
+		{"skips the character in a single pass", false, true, nil, []int64{10, 30}, 1},
+		{"strict", true, false, errHistory, []int64{10}, 0},
+	}
+	defer func(strict, clanMajority bool) { flagStrict, flagClanMajority = strict, clanMajority }(flagStrict, flagClanMajority)
+	defer func(s *skippedMembers) { skipped = s }(skipped)
+	for _, tt := range tests {
+		flagStrict, flagClanMajority = tt.strict, false
+		skipped = newSkippedMembers()
+		history := &activityHistory{
+			user:       &models.UserUserInfoCard{MembershipID: 1, DisplayName: "Name"},
+			singlePass: tt.singlePass,
+			fetch: func(character models.DestinyEntitiesCharactersDestinyCharacterComponent, mode ActivityMode) ([]*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup, error) {
+				if character.CharacterID == 2 {
+					return nil, errHistory
+				}
+				activity := newSoloActivity(10 * character.CharacterID)
+				activity.ActivityDetails.Mode = int64(ModeRaid)
+				return []*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup{activity}, nil
+			},
+			all: make(map[int64][]*models.DestinyHistoricalStatsDestinyHistoricalStatsPeriodGroup),
+		}
+		result := &modeResult{seen: make(map[int64]bool)}
+		err := getEarliestClanCompletion(nil, nil, history, map[int64]bool{1: true}, characters, ModeRaid, result)
+		if err != tt.wantErr {
+			t.Errorf("%v: getEarliestClanCompletion() = %v; want %v", tt.name, err, tt.wantErr)
+		}
+		if len(result.seen) != len(tt.wantSeen) {
+			t.Errorf("%v: evaluated %v; want %v", tt.name, result.seen, tt.wantSeen)
+		}
+		for _, instanceID := range tt.wantSeen {
+			if !result.seen[instanceID] {
+				t.Errorf("%v: instance %v wasn't evaluated", tt.name, instanceID)
+			}
+		}
+		if got := skipped.len(); got != tt.wantSkipped {
+			t.Errorf("%v: skipped %v; want %v", tt.name, got, tt.wantSkipped)
+		}
+	}
+}
+
+func TestIsQualifyingCompletion(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    map[string]float64
+		wantPvE   bool
+		wantPvP   bool
+		wantPanic bool
+	}{
+		{"not completed", map[string]float64{"completed": 0, "standing": 0}, false, false, false},
+		{"won", map[string]float64{"completed": 1, "standing": 0}, true, true, false},
+		{"lost", map[string]float64{"completed": 1, "standing": 1}, true, false, false},
+		{"won by completion reason", map[string]float64{"completed": 1, "completionReason": 0}, true, true, false},
+		{"lost by completion reason", map[string]float64{"completed": 1, "completionReason": 2}, true, false, false},
+		{"no victory state", map[string]float64{"completed": 1}, true, false, true},
+	}
+	for _, m := range allModes {
+		for _, tt := range tests {
+			want := tt.wantPvE
+			if m.pvp {
+				want = tt.wantPvP
+			}
+			activity := newActivity(1, time.Date(2020, time.March, 10, 17, 0, 0, 0, time.UTC), 60)
+			activity.Values = newValues(tt.values)
+			got, panicked := func() (got, panicked bool) {
+				defer func() {
+					if recover() != nil {
+						panicked = true
+					}
+				}()
+				return isQualifyingCompletion(activity, m.mode), false
+			}()
+			if wantPanic := tt.wantPanic && m.pvp; panicked != wantPanic {
+				t.Errorf("%v, %v: isQualifyingCompletion() panicked = %v; want %v", m.key, tt.name, panicked, wantPanic)
+				continue
+			}
+			if !panicked && got != want {
+				t.Errorf("%v, %v: isQualifyingCompletion() = %v; want %v", m.key, tt.name, got, want)
+			}
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	tests := []struct {
+		since   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"3", 3 * 24 * time.Hour, false},
+		{"3d", 3 * 24 * time.Hour, false},
+		{"36h", 36 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"", 0, true},
+		{"d", 0, true},
+		{"three", 0, true},
+		{"0", 0, true},
+		{"0d", 0, true},
+		{"0h", 0, true},
+		{"-3", 0, true},
+		{"-3d", 0, true},
+		{"-36h", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.since)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSince(%q) = %v, %v; want %v, error %v", tt.since, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGetClanTeam(t *testing.T) {
+	tests := []struct {
+		name          string
+		pgcr          *models.DestinyHistoricalStatsDestinyPostGameCarnageReportData
+		clanMemberIDs []int64
+		want          []int64
+	}{
+		{"no teams", newPGCR([]int64{1, 2, 3, 4, 5, 6}), []int64{1, 2}, []int64{1, 2, 3, 4, 5, 6}},
+		{"alpha", newMatch(), []int64{1, 2}, []int64{1, 2, 3, 4, 5, 6}},
+		{"bravo", newMatch(), []int64{7, 8, 9}, []int64{7, 8, 9, 10, 11, 12}},
+		{"both teams", newMatch(), []int64{1, 7, 8}, []int64{7, 8, 9, 10, 11, 12}},
+		{"tie", newMatch(), []int64{1, 7}, []int64{1, 2, 3, 4, 5, 6}},
+		{"no clan members", newMatch(), nil, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+	}
+	for _, tt := range tests {
+		clanMemberIDs := make(map[int64]bool)
+		for _, id := range tt.clanMemberIDs {
+			clanMemberIDs[id] = true
+		}
+		if got := getMembershipIDs(getPGCRFireteam(getClanTeam(tt.pgcr, clanMemberIDs), false)); !equalIDs(got, tt.want) {
+			t.Errorf("%v: getClanTeam() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchClanMajority(t *testing.T) {
+	tests := []struct {
+		name        string
+		clanMembers []int64
+		wantNeeded  int
+		wantCounts  bool
+	}{
+		{"two of the team", []int64{1, 2}, 3, false},
+		{"half of the team", []int64{1, 2, 3}, 3, true},
+		{"most of the team", []int64{1, 2, 3, 4}, 3, true},
+		{"whole team", []int64{1, 2, 3, 4, 5, 6}, 3, true},
+		{"on the other team", []int64{7, 8, 9, 10}, 3, true},
+	}
+	defer func(clanMajority bool) { flagClanMajority = clanMajority }(flagClanMajority)
+	flagClanMajority = true
+	for _, tt := range tests {
+		clanMemberIDs := make(map[int64]bool)
+		for _, id := range tt.clanMembers {
+			clanMemberIDs[id] = true
+		}
+		team := getClanTeam(newMatch(), clanMemberIDs)
+		needed := getClanMembersNeeded(ModeCrucible, len(team.Entries))
+		if needed != tt.wantNeeded {
+			t.Errorf("%v: %v clan members needed; want %v", tt.name, needed, tt.wantNeeded)
+		}
+		clanFireteam := extractClanFireteam(getPGCRFireteam(team, true), clanMemberIDs)
+		if counts := len(clanFireteam) >= needed; counts != tt.wantCounts {
+			t.Errorf("%v: counts = %v; want %v", tt.name, counts, tt.wantCounts)
+		}
+	}
+}
+
+func TestIsCarry(t *testing.T) {
+	tests := []struct {
+		name          string
+		pgcr          *models.DestinyHistoricalStatsDestinyPostGameCarnageReportData
+		clanMemberIDs []int64
+		want          bool
+	}{
+		{"pve minority", newPGCR([]int64{1, 2, 3, 4, 5, 6}), []int64{1, 2}, true},
+		{"pve half", newPGCR([]int64{1, 2, 3, 4, 5, 6}), []int64{1, 2, 3}, false},
+		{"pvp whole team", newMatch(), []int64{1, 2, 3, 4, 5, 6}, false},
+		{"pvp half", newMatch(), []int64{7, 8, 9}, false},
+		{"pvp minority", newMatch(), []int64{1, 2}, true},
+	}
+	for _, tt := range tests {
+		clanMemberIDs := make(map[int64]bool)
+		for _, id := range tt.clanMemberIDs {
+			clanMemberIDs[id] = true
+		}
+		team := getClanTeam(tt.pgcr, clanMemberIDs)
+		c := &completion{
+			fireteamMembers: extractClanFireteam(getPGCRFireteam(team, true), clanMemberIDs),
+			fireteamSize:    len(team.Entries),
+		}
+		if got := c.isCarry(); got != tt.want {
+			t.Errorf("%v: isCarry() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
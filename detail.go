@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/zhirsch/destiny2-api/client"
+	db "github.com/zhirsch/destiny2-db"
+)
+
+// CompletionDetails describes a single activity instance and whether it counts
+// as a clan completion.
+type CompletionDetails struct {
+	InstanceID   int64     `json:"instanceId"`
+	ActivityName string    `json:"activityName"`
+	Mode         string    `json:"mode"`
+	Period       time.Time `json:"period"`
+	Completed    bool      `json:"completed"`
+	Victory      bool      `json:"victory"`
+	// ClanFireteam and OtherFireteam are the players who completed the
+	// activity that are and aren't in the clan.
+	ClanFireteam  []string `json:"clanFireteam"`
+	OtherFireteam []string `json:"otherFireteam"`
+	// MinClanMembersNeeded is the number of clan members needed for the
+	// completion to count, or 0 if the mode isn't tracked.
+	MinClanMembersNeeded int  `json:"minClanMembersNeeded"`
+	Counts               bool `json:"counts"`
+}
+
+// GetCompletionDetails returns the details of the activity instance, with its
+// fireteam partitioned into members and non-members of the clan. The manifest
+// can be nil.
+func GetCompletionDetails(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, manifest *db.DB, instanceID int64, clanMemberIDs map[int64]bool) (*CompletionDetails, error) {
+	pgcr, err := getPostGameCarnageReport(api, auth, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	details := &CompletionDetails{
+		InstanceID: instanceID,
+		Mode:       fmt.Sprint(pgcr.ActivityDetails.Mode),
+		Period:     time.Time(pgcr.Period),
+	}
+	details.ActivityName = getActivityName(manifest, pgcr.ActivityDetails.ReferenceID)
+
+	// Only the clan's team is its fireteam, and its result is the clan's.
+	pgcr = getClanTeam(pgcr, clanMemberIDs)
+	for _, entry := range pgcr.Entries {
+		if entry.Values["completed"].Basic.Value != 0 {
+			details.Completed = true
+			details.Victory, _ = isVictory(entry.Values)
+			break
+		}
+	}
+	clan, others := partitionFireteam(getPGCRFireteam(pgcr, true), clanMemberIDs)
+	for _, userInfo := range clan {
+		details.ClanFireteam = append(details.ClanFireteam, getDisplayName(userInfo))
+	}
+	for _, userInfo := range others {
+		details.OtherFireteam = append(details.OtherFireteam, getDisplayName(userInfo))
+	}
+
+	if m, ok := findMode(ActivityMode(pgcr.ActivityDetails.Mode)); ok {
+		details.Mode = m.name
+		if !m.pvp {
+			details.Victory = details.Completed
+		}
+		details.MinClanMembersNeeded = getClanMembersNeeded(m.mode, len(pgcr.Entries))
+		details.Counts = details.Completed && details.Victory && len(details.ClanFireteam) >= details.MinClanMembersNeeded
+	}
+	return details, nil
+}
+
+func runDetail() {
+	api, auth, _, err := newAPI()
+	if err != nil {
+		fatal(err)
+	}
+	// Without the manifest, the activity is shown by its hash.
+	manifest, err := db.Open(api, auth)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: unable to open the manifest: %v\n", err)
+		manifest = nil
+	}
+	user, err := getUser(api, auth)
+	if err != nil {
+		fatal(err)
+	}
+	clan, err := getClan(api, auth, user)
+	if err != nil {
+		fatal(err)
+	}
+	clanMembers, _, err := getCachedMembers(api, auth, clan.GroupID)
+	if err != nil {
+		fatal(err)
+	}
+	details, err := GetCompletionDetails(api, auth, manifest, flagInstance, getClanMemberIDs(clanMembers))
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("Activity:       %v (%v)\n", details.ActivityName, details.Mode)
+	fmt.Printf("Started:        %v\n", details.Period)
+	fmt.Printf("Completed:      %v\n", details.Completed)
+	fmt.Printf("Victory:        %v\n", details.Victory)
+	fmt.Printf("Clan fireteam:  %v\n", strings.Join(details.ClanFireteam, ","))
+	fmt.Printf("Other fireteam: %v\n", strings.Join(details.OtherFireteam, ","))
+	if details.MinClanMembersNeeded == 0 {
+		fmt.Printf("Counts:         false (mode isn't tracked)\n")
+	} else {
+		fmt.Printf("Counts:         %v (needs %v clan members)\n", details.Counts, details.MinClanMembersNeeded)
+	}
+}
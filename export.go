@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// exportedMember is a clan member as written by export-members.
+type exportedMember struct {
+	MembershipID   int64     `json:"membershipId"`
+	MembershipType int64     `json:"membershipType"`
+	BungieName     string    `json:"bungieName"`
+	MemberType     int64     `json:"memberType"`
+	JoinDate       time.Time `json:"joinDate"`
+	LastOnline     time.Time `json:"lastOnline"`
+}
+
+func newExportedMember(member *ClanMember) *exportedMember {
+	return &exportedMember{
+		MembershipID:   member.UserInfo.MembershipID,
+		MembershipType: int64(member.UserInfo.MembershipType),
+		BungieName:     getDisplayName(member.UserInfo),
+		MemberType:     member.MemberType,
+		JoinDate:       member.JoinDate,
+		LastOnline:     member.LastOnlineStatusChange,
+	}
+}
+
+// writeMembersCSV writes the members as CSV with a header row.
+func writeMembersCSV(w io.Writer, members []*exportedMember) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"membershipId", "membershipType", "bungieName", "memberType", "joinDate", "lastOnline"}); err != nil {
+		return err
+	}
+	for _, member := range members {
+		record := []string{
+			strconv.FormatInt(member.MembershipID, 10),
+			strconv.FormatInt(member.MembershipType, 10),
+			member.BungieName,
+			strconv.FormatInt(member.MemberType, 10),
+			member.JoinDate.UTC().Format(time.RFC3339),
+			member.LastOnline.UTC().Format(time.RFC3339),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeMembersJSON writes the members as a JSON array.
+func writeMembersJSON(w io.Writer, members []*exportedMember) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(members)
+}
+
+// exportMembers writes the clan's roster to w in the format, without scanning
+// any activities.
+func exportMembers(w io.Writer, format string) error {
+	var write func(io.Writer, []*exportedMember) error
+	switch format {
+	case "csv":
+		write = writeMembersCSV
+	case "json":
+		write = writeMembersJSON
+	default:
+		return errors.Errorf("unknown export format %q", format)
+	}
+	api, auth, _, err := newAPI()
+	if err != nil {
+		return err
+	}
+	_, clan, err := getUserAndClan(api, auth)
+	if err != nil {
+		return err
+	}
+	clanMembers, _, err := getCachedMembers(api, auth, clan.GroupID)
+	if err != nil {
+		return err
+	}
+	if err := sortMembers(clanMembers, flagSort); err != nil {
+		return err
+	}
+	members := make([]*exportedMember, 0, len(clanMembers))
+	for _, member := range clanMembers {
+		members = append(members, newExportedMember(member))
+	}
+	return write(redactWriter(w), members)
+}
+
+func runExportMembers() {
+	if flagExportOutput == "" || flagExportOutput == "-" {
+		if err := exportMembers(os.Stdout, flagExportFormat); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	f, err := os.Create(flagExportOutput)
+	if err != nil {
+		fatal(err)
+	}
+	if err := exportMembers(f, flagExportFormat); err != nil {
+		f.Close()
+		fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,13 @@
+module github.com/zhirsch/destinyclanrewards
+
+go 1.13
+
+require github.com/pkg/errors v0.9.1
+
+// github.com/zhirsch/destiny2-api and github.com/zhirsch/destiny2-db (and the
+// github.com/go-openapi packages that destiny2-api is generated against)
+// aren't on the module proxy, so they're pinned by running
+//
+//	go get github.com/zhirsch/destiny2-api@master github.com/zhirsch/destiny2-db@master
+//
+// with access to GitHub.
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultHTMLTemplate is the built-in template for --format=html.
+const defaultHTMLTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Clan {{.ClanID}} rewards</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: left; }
+.earned { color: green; }
+</style>
+</head>
+<body>
+<h1>Clan {{.ClanID}}</h1>
+{{with .Roster}}<p>{{.MemberCount}} members</p>{{end}}
+{{if and .Roster (not .Weeks)}}
+<table>
+<tr><th>Membership ID</th><th>Name</th><th>Joined</th></tr>
+{{range .Roster.Members}}<tr><td>{{.UserInfo.MembershipID}}</td><td>{{name .UserInfo}}</td><td>{{time .JoinDate}}</td></tr>
+{{end}}</table>
+{{end}}
+{{range .Weeks}}
+<h2>{{.Reward.Name}}</h2>
+{{if .Current}}<p>Current week, ends in {{duration .RemainingSeconds}} ({{time .End}})</p>{{else if .Reward.Total}}<p>Past week, ended {{time .End}}</p>{{end}}
+<p>{{time .Start}} to {{time .End}}</p>
+{{if .Reward.Entries}}
+<table>
+<tr><th>Reward</th><th>Earned</th><th>Progress</th></tr>
+{{range .Reward.Entries}}<tr><td>{{.Name}}</td><td>{{if .Earned}}<span class="earned">&#10003;</span>{{end}}</td><td>{{.Progress}}/{{.CompletionValue}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Completions}}
+<table>
+<tr><th>Mode</th><th>Activity</th><th>Completed</th><th>Duration</th><th>Fireteam</th></tr>
+{{range .Completions}}<tr><td>{{.Mode}}</td><td>{{.Tier}} {{.Activity}}</td><td>{{time .End}}</td><td>{{duration .DurationSeconds}}</td><td>{{range $i, $name := .Fireteam}}{{if $i}}, {{end}}{{$name}}{{end}}{{if .Guests}} (guests: {{range $i, $name := .Guests}}{{if $i}}, {{end}}{{$name}}{{end}}){{end}}{{if .Carry}} (carry){{end}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Complete}}<p>All rewards earned; the completions weren't scanned</p>{{else}}<p>{{.Summary.ModesCompleted}}/{{.Summary.ModesTracked}} modes completed</p>{{end}}
+{{if .Summary.SampledMembers}}<p>Partial sample: only {{.Summary.SampledMembers}} of {{.Summary.ClanMembers}} clan members were scanned</p>{{end}}
+{{if .Summary.PartialRoster}}<p>Partial roster: only the {{.Summary.ClanMembers}} clan members that were fetched were scanned</p>{{end}}
+{{if .Summary.Interrupted}}<p>(partial, interrupted): only the completions found before the run was interrupted</p>{{end}}
+{{end}}
+{{with .Contributors}}
+<h2>Top contributors</h2>
+<table>
+<tr><th>Name</th><th>Completions</th></tr>
+{{range .Contributors}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+{{with .Leaderboard}}
+<h2>Leaderboard</h2>
+<table>
+<tr><th>Name</th><th>Completions</th><th>Modes</th></tr>
+{{range .Contributors}}<tr><td>{{.Name}}</td><td>{{.Count}}</td><td>{{range $mode, $count := .Modes}}{{$mode}}: {{$count}} {{end}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`
+
+// newHTMLReportWriter returns a reportWriter that writes all of the reports as
+// a single HTML page, using the template in the file or the built-in template
+// if path is empty.
+func newHTMLReportWriter(w io.Writer, loc *time.Location, layout, path string) (*collectedReportWriter, error) {
+	text := &textReportWriter{w: w, loc: loc, layout: layout}
+	tmpl := template.New("html").Funcs(template.FuncMap{
+		"time":     text.formatTime,
+		"name":     getDisplayName,
+		"duration": formatDurationSeconds,
+	})
+	source := defaultHTMLTemplate
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		source = string(data)
+	}
+	tmpl, err := tmpl.Parse(source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid template %q", path)
+	}
+	return &collectedReportWriter{w: w, tmpl: tmpl}, nil
+}
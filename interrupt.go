@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// exitCodeInterrupted is the exit code when the report was interrupted, like a
+// shell's for SIGINT.
+const exitCodeInterrupted = 130
+
+// errInterrupted is returned by a scan that stopped because the run was
+// interrupted, along with the results found so far.
+var errInterrupted = errors.New("interrupted")
+
+// interrupted is closed when the run is interrupted, so that the scans stop
+// and the completions found so far are reported.
+var interrupted = make(chan struct{})
+
+func isInterrupted() bool {
+	select {
+	case <-interrupted:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleInterrupts closes interrupted at the first interrupt or SIGTERM, and
+// exits at the second. The returned function stops handling them.
+func handleInterrupts() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fmt.Fprintln(os.Stderr, "interrupted; reporting the completions found so far (interrupt again to exit now)")
+		close(interrupted)
+		select {
+		case <-signals:
+			os.Exit(exitCodeInterrupted)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// The verbosity levels. logger logs at levelSteps; memberLogger and
+// detailLogger log at the noisier levels.
+const (
+	// levelSteps logs the high level steps: the user, the clan, its members
+	// and each week.
+	levelSteps = 1
+	// levelMembers also logs each clan member and mode.
+	levelMembers = 2
+	// levelDetail also logs each activity and post game carnage report.
+	levelDetail = 3
+)
+
+var (
+	memberLogger *log.Logger
+	detailLogger *log.Logger
+)
+
+// verbosityFlag is a boolean flag that raises the verbosity by n each time it's
+// given, so that -v -v and -vv are the same.
+type verbosityFlag struct {
+	level *int
+	n     int
+}
+
+func (f verbosityFlag) IsBoolFlag() bool { return true }
+
+func (f verbosityFlag) String() string { return "" }
+
+func (f verbosityFlag) Set(value string) error {
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if on {
+		*f.level += f.n
+	}
+	return nil
+}
+
+func addVerbosityFlags(fs *flag.FlagSet) {
+	fs.Var(verbosityFlag{&flagVerbosity, 1}, "v", "log the high level steps; repeat (-vv, -vvv) to log more")
+	fs.Var(verbosityFlag{&flagVerbosity, 2}, "vv", "log each clan member and mode too")
+	fs.Var(verbosityFlag{&flagVerbosity, 3}, "vvv", "log each activity and post game carnage report too")
+	fs.IntVar(&flagLogLevel, "log-level", 0, "the verbosity (0 none, 1 steps, 2 members and modes, 3 activities)")
+}
+
+// getVerbosity returns the verbosity from the flags. --verbose logs everything,
+// as it always has.
+func getVerbosity() int {
+	level := flagVerbosity
+	if flagLogLevel > level {
+		level = flagLogLevel
+	}
+	if flagVerbose {
+		level = levelDetail
+	}
+	return level
+}
+
+// newLevelLogger returns a logger to stderr if the verbosity is at least the
+// level, or one that discards everything otherwise. The logs are redacted with
+// --redact.
+func newLevelLogger(verbosity, level int) *log.Logger {
+	if verbosity >= level {
+		return log.New(redactWriter(os.Stderr), "", log.LstdFlags)
+	}
+	return log.New(ioutil.Discard, "", log.LstdFlags)
+}
+
+// setUpLoggers creates the loggers for the verbosity.
+func setUpLoggers(verbosity int) {
+	logger = newLevelLogger(verbosity, levelSteps)
+	memberLogger = newLevelLogger(verbosity, levelMembers)
+	detailLogger = newLevelLogger(verbosity, levelDetail)
+}
+
+// fatal writes the error to stderr and exits, with exitCodeMaintenance if the
+// Bungie API is in maintenance. Unlike logger.Fatal, the error is written
+// whatever the verbosity.
+func fatal(err error) {
+	fmt.Fprintln(redactWriter(os.Stderr), err)
+	if errors.Cause(err) == errMaintenance {
+		os.Exit(exitCodeMaintenance)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync"
+
+	db "github.com/zhirsch/destiny2-db"
+)
+
+// definitionKey identifies a definition in the manifest.
+type definitionKey struct {
+	table string
+	hash  uint32
+}
+
+// definitions are the manifest definitions that have been looked up, so that
+// each is only read from the manifest once.
+var definitions = struct {
+	sync.Mutex
+	m map[definitionKey]interface{}
+}{m: make(map[definitionKey]interface{})}
+
+// getDefinition returns the definition from the manifest table, like
+// manifest.Get, but remembers it for the next lookup.
+func getDefinition(manifest *db.DB, table string, hash uint32, v interface{}) (interface{}, error) {
+	key := definitionKey{table, hash}
+	definitions.Lock()
+	definition, ok := definitions.m[key]
+	definitions.Unlock()
+	if ok {
+		return definition, nil
+	}
+	definition, err := manifest.Get(table, hash, v)
+	if err != nil {
+		return nil, err
+	}
+	definitions.Lock()
+	definitions.m[key] = definition
+	definitions.Unlock()
+	return definition, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// readMembersFile returns the display names or membership IDs in the file,
+// one per line. Blank lines and lines starting with # are skipped.
+func readMembersFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// filterMembers returns the clan members named by display name or membership
+// ID in entries. Entries that don't match a clan member are logged and
+// skipped.
+func filterMembers(clanMembers []*ClanMember, entries []string) []*ClanMember {
+	var filtered []*ClanMember
+	for _, entry := range entries {
+		var found *ClanMember
+		id, err := strconv.ParseInt(entry, 10, 64)
+		for _, clanMember := range clanMembers {
+			if (err == nil && clanMember.UserInfo.MembershipID == id) || strings.EqualFold(clanMember.UserInfo.DisplayName, entry) || strings.EqualFold(getDisplayName(clanMember.UserInfo), entry) {
+				found = clanMember
+				break
+			}
+		}
+		if found == nil {
+			// Written whatever the verbosity, since a typo in the file
+			// would otherwise silently leave the member out.
+			fmt.Fprintf(redactWriter(os.Stderr), "warning: %q from the members file is not in the clan\n", entry)
+			continue
+		}
+		filtered = append(filtered, found)
+	}
+	return filtered
+}
+
+// parseMemberIDs parses a comma-separated list of membership IDs. An entry
+// starting with @ names a file of membership IDs, one per line.
+func parseMemberIDs(list string) (map[int64]bool, error) {
+	ids := make(map[int64]bool)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		entries := []string{entry}
+		if strings.HasPrefix(entry, "@") {
+			var err error
+			entries, err = readMembersFile(entry[1:])
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, entry := range entries {
+			id, err := strconv.ParseInt(entry, 10, 64)
+			if err != nil {
+				return nil, errors.Errorf("invalid membership ID %q", entry)
+			}
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
+
+// hasMembershipID returns whether any of the clan member's memberships are in
+// ids.
+func hasMembershipID(clanMember *ClanMember, ids map[int64]bool) bool {
+	if ids[clanMember.UserInfo.MembershipID] {
+		return true
+	}
+	for _, id := range clanMember.AlternateMembershipIDs {
+		if ids[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// getAttributionMembers returns the clan members that completions are
+// attributed to: those in --include-members, if it's given, that aren't in
+// --exclude-members.
+func getAttributionMembers(clanMembers []*ClanMember) ([]*ClanMember, error) {
+	if flagIncludeMembers == "" && flagExcludeMembers == "" {
+		return clanMembers, nil
+	}
+	include, err := parseMemberIDs(flagIncludeMembers)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := parseMemberIDs(flagExcludeMembers)
+	if err != nil {
+		return nil, err
+	}
+	var members []*ClanMember
+	for _, clanMember := range clanMembers {
+		if flagIncludeMembers != "" && !hasMembershipID(clanMember, include) {
+			continue
+		}
+		if hasMembershipID(clanMember, exclude) {
+			continue
+		}
+		members = append(members, clanMember)
+	}
+	logger.Printf("attributing completions to %v of %v clan members", len(members), len(clanMembers))
+	return members, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	runtime_client "github.com/go-openapi/runtime/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	oauthAuthorizeURL = "https://www.bungie.net/en/OAuth/Authorize"
+	oauthTokenURL     = "https://www.bungie.net/platform/app/oauth/token/"
+)
+
+// oauthToken is a Bungie OAuth access token, as returned by the token endpoint
+// and stored in the token file.
+type oauthToken struct {
+	AccessToken      string    `json:"access_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresIn        int64     `json:"expires_in"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresIn int64     `json:"refresh_expires_in"`
+	MembershipID     string    `json:"membership_id"`
+	Expiry           time.Time `json:"expiry"`
+	RefreshExpiry    time.Time `json:"refresh_expiry"`
+}
+
+// getOAuthToken returns a valid access token. The token is read from the token
+// file and refreshed if it has expired. If there is no usable token, the user
+// is asked to authorize the application and paste the resulting code.
+func getOAuthToken(clientID, clientSecret, tokenFile string) (*oauthToken, error) {
+	var token oauthToken
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &token); err != nil {
+			return nil, errors.Wrapf(err, "invalid token file %v", tokenFile)
+		}
+		if time.Now().Before(token.Expiry) {
+			return &token, nil
+		}
+	}
+
+	form := url.Values{}
+	if token.RefreshToken != "" && time.Now().Before(token.RefreshExpiry) {
+		logger.Printf("refreshing oauth token")
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", token.RefreshToken)
+	} else {
+		fmt.Fprintf(os.Stderr, "Authorize this application by visiting:\n  %v?client_id=%v&response_type=code\nThen enter the code from the redirect URL: ", oauthAuthorizeURL, url.QueryEscape(clientID))
+		code, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		form.Set("grant_type", "authorization_code")
+		form.Set("code", strings.TrimSpace(code))
+	}
+	newToken, err := requestOAuthToken(clientID, clientSecret, form)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = json.Marshal(newToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(tokenFile, data, 0600); err != nil {
+		return nil, err
+	}
+	return newToken, nil
+}
+
+func requestOAuthToken(clientID, clientSecret string, form url.Values) (*oauthToken, error) {
+	req, err := http.NewRequest("POST", oauthTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, errors.Errorf("oauth token request failed: %v: %s", resp.Status, body)
+	}
+	var token oauthToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	token.Expiry = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	token.RefreshExpiry = now.Add(time.Duration(token.RefreshExpiresIn) * time.Second)
+	return &token, nil
+}
+
+// newAuth returns the authentication for API calls: the API key, and the OAuth
+// access token if a client ID is given.
+func newAuth(apiKey, clientID, clientSecret, tokenFile string) (runtime.ClientAuthInfoWriter, bool, error) {
+	auth := runtime_client.APIKeyAuth("X-API-Key", "header", apiKey)
+	if clientID == "" {
+		return auth, false, nil
+	}
+	token, err := getOAuthToken(clientID, clientSecret, tokenFile)
+	if err != nil {
+		return nil, false, err
+	}
+	return runtime_client.Compose(auth, runtime_client.BearerToken(token.AccessToken)), true, nil
+}
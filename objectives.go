@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/zhirsch/destiny2-api/models"
+	db "github.com/zhirsch/destiny2-db"
+)
+
+// getObjectiveDefinition returns the definition of the objective.
+func getObjectiveDefinition(manifest *db.DB, objectiveHash int64) (*models.DestinyDefinitionsDestinyObjectiveDefinition, error) {
+	objectiveDefinitionInterface, err := getDefinition(manifest, "DestinyObjectiveDefinition", uint32(objectiveHash), &models.DestinyDefinitionsDestinyObjectiveDefinition{})
+	if err != nil {
+		return nil, err
+	}
+	return objectiveDefinitionInterface.(*models.DestinyDefinitionsDestinyObjectiveDefinition), nil
+}
+
+// getRewardObjectives returns the progress of the milestone's challenge
+// objectives by the hash of the reward entry that each is toward, which is the
+// entry named by the objective's progress description or name. Objectives that
+// can't be looked up or aren't toward an entry are left out.
+func getRewardObjectives(manifest *db.DB, rewards *models.DestinyMilestonesDestinyMilestone, milestoneDefinition *models.DestinyDefinitionsMilestonesDestinyMilestoneDefinition) map[int64]*models.DestinyQuestsDestinyObjectiveProgress {
+	entryHashes := make(map[string]int64)
+	for _, rewardCategory := range milestoneDefinition.Rewards {
+		for _, rewardEntry := range rewardCategory.RewardEntries {
+			if rewardEntry.DisplayProperties != nil && rewardEntry.DisplayProperties.Name != "" {
+				entryHashes[strings.ToLower(rewardEntry.DisplayProperties.Name)] = rewardEntry.RewardEntryHash
+			}
+		}
+	}
+	objectives := make(map[int64]*models.DestinyQuestsDestinyObjectiveProgress)
+	for _, activity := range rewards.Activities {
+		for _, challenge := range activity.Challenges {
+			if challenge.Objective == nil {
+				continue
+			}
+			objectiveDefinition, err := getObjectiveDefinition(manifest, challenge.Objective.ObjectiveHash)
+			if err != nil {
+				memberLogger.Printf("unable to get the definition of objective %v: %v", challenge.Objective.ObjectiveHash, err)
+				continue
+			}
+			names := []string{objectiveDefinition.ProgressDescription}
+			if objectiveDefinition.DisplayProperties != nil {
+				names = append(names, objectiveDefinition.DisplayProperties.Name)
+			}
+			for _, name := range names {
+				if entryHash, ok := entryHashes[strings.ToLower(name)]; ok && name != "" {
+					objective := *challenge.Objective
+					if objective.CompletionValue == 0 {
+						objective.CompletionValue = objectiveDefinition.CompletionValue
+					}
+					objectives[entryHash] = &objective
+					break
+				}
+			}
+		}
+	}
+	return objectives
+}
+
+// getRewardEntryProgress returns the progress toward the reward entry out of
+// its completion value. An entry without an objective is 1/1 if it has been
+// earned and 0/1 otherwise.
+func getRewardEntryProgress(entry *models.DestinyMilestonesDestinyMilestoneRewardEntry, objective *models.DestinyQuestsDestinyObjectiveProgress) (int, int) {
+	if objective == nil || objective.CompletionValue <= 0 {
+		if entry.Earned {
+			return 1, 1
+		}
+		return 0, 1
+	}
+	progress := int(objective.Progress)
+	// The objective can go past its completion value, and an earned entry
+	// is complete whatever its objective says.
+	if progress > int(objective.CompletionValue) || entry.Earned {
+		progress = int(objective.CompletionValue)
+	}
+	return progress, int(objective.CompletionValue)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-openapi/runtime"
+	"github.com/pkg/errors"
+	"github.com/zhirsch/destiny2-api/client"
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+// Profile components that are handled.
+const (
+	componentCharacters = 200
+	componentRecords    = 900
+)
+
+// profileComponentNames are the names of the known profile components.
+var profileComponentNames = map[int64]string{
+	100:  "Profiles",
+	101:  "VendorReceipts",
+	102:  "ProfileInventories",
+	103:  "ProfileCurrencies",
+	104:  "ProfileProgression",
+	105:  "PlatformSilver",
+	200:  "Characters",
+	201:  "CharacterInventories",
+	202:  "CharacterProgressions",
+	203:  "CharacterRenderData",
+	204:  "CharacterActivities",
+	205:  "CharacterEquipment",
+	206:  "CharacterLoadouts",
+	300:  "ItemInstances",
+	301:  "ItemObjectives",
+	302:  "ItemPerks",
+	303:  "ItemRenderData",
+	304:  "ItemStats",
+	305:  "ItemSockets",
+	306:  "ItemTalentGrids",
+	307:  "ItemCommonData",
+	308:  "ItemPlugStates",
+	309:  "ItemPlugObjectives",
+	310:  "ItemReusablePlugs",
+	400:  "Vendors",
+	401:  "VendorCategories",
+	402:  "VendorSales",
+	500:  "Kiosks",
+	600:  "CurrencyLookups",
+	700:  "PresentationNodes",
+	800:  "Collectibles",
+	900:  "Records",
+	1000: "Transitory",
+	1100: "Metrics",
+	1200: "StringVariables",
+	1300: "Craftables",
+	1400: "SocialCommendations",
+}
+
+// profileComponents are the components that getCharacters requests: the
+// characters, and any others from --profile-components.
+var profileComponents = []int64{componentCharacters}
+
+// parseProfileComponents returns the components in the comma-separated list of
+// IDs or names, after the characters component that is always needed.
+func parseProfileComponents(list string) ([]int64, error) {
+	components := []int64{componentCharacters}
+	seen := map[int64]bool{componentCharacters: true}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		component, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			component = findProfileComponent(s)
+		}
+		if _, ok := profileComponentNames[component]; !ok {
+			return nil, errors.Errorf("unknown profile component %q", s)
+		}
+		if !seen[component] {
+			seen[component] = true
+			components = append(components, component)
+		}
+	}
+	return components, nil
+}
+
+// findProfileComponent returns the component with the name, or 0 if there is
+// none.
+func findProfileComponent(name string) int64 {
+	for component, componentName := range profileComponentNames {
+		if strings.EqualFold(componentName, name) {
+			return component
+		}
+	}
+	return 0
+}
+
+func hasProfileComponent(component int64) bool {
+	for _, c := range profileComponents {
+		if c == component {
+			return true
+		}
+	}
+	return false
+}
+
+// profileData is the data from the extra profile components of each user whose
+// profile has been fetched.
+type profileData struct {
+	mu            sync.Mutex
+	triumphScores map[int64]int64
+}
+
+var profiles = &profileData{triumphScores: make(map[int64]int64)}
+
+// record keeps the data from the extra components in the user's profile.
+func (p *profileData) record(user *models.UserUserInfoCard, profile *models.DestinyResponsesDestinyProfileResponse) {
+	if profile.ProfileRecords == nil || profile.ProfileRecords.Data == nil {
+		return
+	}
+	score := int64(profile.ProfileRecords.Data.Score)
+	memberLogger.Printf("triumph score of destiny user %v (%q): %v", user.MembershipID, user.DisplayName, score)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.triumphScores[user.MembershipID] = score
+}
+
+// getTriumphScores returns the triumph scores of the members whose profiles
+// had them, by membership ID.
+func (p *profileData) getTriumphScores(members []*ClanMember) map[int64]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	scores := make(map[int64]int64)
+	for _, member := range members {
+		if score, ok := p.triumphScores[member.UserInfo.MembershipID]; ok {
+			scores[member.UserInfo.MembershipID] = score
+		}
+	}
+	return scores
+}
+
+// getRosterTriumphScores returns the triumph scores of the members by
+// membership ID if they were requested with --profile-components, and nil
+// otherwise. The roster doesn't have them, so each member's profile is got.
+func getRosterTriumphScores(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, members []*ClanMember) map[int64]int64 {
+	if !hasProfileComponent(componentRecords) {
+		return nil
+	}
+	for _, member := range members {
+		if _, err := getCharacters(api, auth, member.UserInfo); err != nil {
+			logger.Printf("warning: unable to get the profile of clan member %v (%q): %v", member.UserInfo.MembershipID, member.UserInfo.DisplayName, err)
+		}
+	}
+	return profiles.getTriumphScores(members)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// progressReporter shows the progress of long scans on a single line that is
+// overwritten in place.
+type progressReporter struct {
+	w       io.Writer
+	enabled bool
+}
+
+// newProgressReporter returns a progressReporter that writes to f if it is a
+// terminal and enabled is set.
+func newProgressReporter(f *os.File, enabled bool) *progressReporter {
+	if enabled {
+		info, err := f.Stat()
+		enabled = err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+	return &progressReporter{f, enabled}
+}
+
+func (p *progressReporter) Printf(format string, v ...interface{}) {
+	if !p.enabled {
+		return
+	}
+	fmt.Fprintf(p.w, "\r\033[K"+format, v...)
+}
+
+func (p *progressReporter) Clear() {
+	if !p.enabled {
+		return
+	}
+	fmt.Fprint(p.w, "\r\033[K")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+)
+
+// membershipIDPattern matches Destiny membership IDs, which all start with
+// 4611686018 on every platform.
+var membershipIDPattern = regexp.MustCompile(`\b4611686018\d{9}\b`)
+
+// redactedIDBase is the start of the range of the placeholders. They are
+// numbers so that the JSON and CSV output stays valid, but are well outside
+// of the range of real membership IDs.
+const redactedIDBase = 1000000000000000000
+
+// redactor replaces the membership IDs in text with placeholders. Each ID
+// gets the same placeholder for the whole run, but a different one each run.
+type redactor struct {
+	mu           sync.Mutex
+	salt         [16]byte
+	placeholders map[string]string
+}
+
+func newRedactor() *redactor {
+	r := &redactor{placeholders: make(map[string]string)}
+	if _, err := rand.Read(r.salt[:]); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func (r *redactor) placeholder(id []byte) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.placeholders[string(id)]; ok {
+		return []byte(p)
+	}
+	h := sha256.New()
+	h.Write(r.salt[:])
+	h.Write(id)
+	sum := binary.BigEndian.Uint64(h.Sum(nil))
+	p := fmt.Sprint(redactedIDBase + sum%1000000000)
+	r.placeholders[string(id)] = p
+	return []byte(p)
+}
+
+func (r *redactor) redact(p []byte) []byte {
+	return membershipIDPattern.ReplaceAllFunc(p, r.placeholder)
+}
+
+// redaction redacts the logs and output with --redact.
+var redaction *redactor
+
+// redactingWriter redacts what is written to w. Digits at the end of a write
+// are held until the next write or Flush, since they might be the start of an
+// ID that the next write finishes.
+type redactingWriter struct {
+	w       io.Writer
+	pending []byte
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	buf := append(rw.pending, p...)
+	n := len(buf)
+	for n > 0 && buf[n-1] >= '0' && buf[n-1] <= '9' {
+		n--
+	}
+	if _, err := rw.w.Write(redaction.redact(buf[:n])); err != nil {
+		return 0, err
+	}
+	rw.pending = append([]byte(nil), buf[n:]...)
+	return len(p), nil
+}
+
+// Flush writes the held digits.
+func (rw *redactingWriter) Flush() error {
+	if len(rw.pending) == 0 {
+		return nil
+	}
+	_, err := rw.w.Write(redaction.redact(rw.pending))
+	rw.pending = nil
+	return err
+}
+
+// redactWriter returns a writer that redacts what is written to w with
+// --redact, and w otherwise.
+func redactWriter(w io.Writer) io.Writer {
+	if redaction == nil {
+		return w
+	}
+	return &redactingWriter{w: w}
+}
+
+// redactingReportWriter is a reportWriter whose output is redacted.
+type redactingReportWriter struct {
+	reportWriter
+	rw *redactingWriter
+}
+
+func (r *redactingReportWriter) Flush() error {
+	if err := r.reportWriter.Flush(); err != nil {
+		return err
+	}
+	return r.rw.Flush()
+}
@@ -0,0 +1,679 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+// WeekReport is the reward state and clan completions for a single week.
+type WeekReport struct {
+	ClanID      int64                `json:"clanId"`
+	Start       time.Time            `json:"start"`
+	End         time.Time            `json:"end"`
+	Reward      RewardCategoryReport `json:"reward"`
+	Completions []*CompletionReport  `json:"completions"`
+	Summary     SummaryReport        `json:"summary"`
+	// Complete is set when all of the rewards had been earned and so the
+	// completions weren't scanned, with --stop-when-complete.
+	Complete bool `json:"complete,omitempty"`
+	// NewlyEarned are the reward entries that have been earned since the
+	// last run with the same --state-file.
+	NewlyEarned []string `json:"newlyEarned,omitempty"`
+	// Attempts are the clan activities that weren't completed.
+	Attempts []*AttemptReport `json:"attempts,omitempty"`
+	// JoinedAfterStart are the members who joined the clan after the week
+	// started.
+	JoinedAfterStart []string `json:"joinedAfterStart,omitempty"`
+	// Platforms are the number of clan members on each platform who were in
+	// the fireteam of a clan completion.
+	Platforms map[string]int `json:"platforms,omitempty"`
+	// Current is whether the week is the current reward week, and
+	// RemainingSeconds is how long until it ends, to the hour so that
+	// --watch doesn't see a change every run.
+	Current          bool  `json:"current"`
+	RemainingSeconds int64 `json:"remainingSeconds,omitempty"`
+}
+
+// RewardCategoryReport is a clan reward category and its entries.
+type RewardCategoryReport struct {
+	Name string `json:"name"`
+	// Entries are only the earned or unearned entries with --only-earned or
+	// --only-unearned.
+	Entries []RewardEntryReport `json:"entries"`
+	// Total is the number of entries, including any that were filtered out.
+	Total int `json:"total"`
+	// Earned is the number of entries that have been earned, and Percent is
+	// that as a percentage of all the entries.
+	Earned  int     `json:"earned"`
+	Percent float64 `json:"percent"`
+	// allEntries are all of the entries, for the state to compare.
+	allEntries []RewardEntryReport
+}
+
+// RewardEntryReport is a single clan reward and whether it has been earned.
+type RewardEntryReport struct {
+	Name   string `json:"name"`
+	Earned bool   `json:"earned"`
+	// Progress is the progress toward the entry's objective out of
+	// CompletionValue, and ProgressPercent is that as a percentage.
+	Progress        int     `json:"progress"`
+	CompletionValue int     `json:"completionValue"`
+	ProgressPercent float64 `json:"progressPercent"`
+	// Redeemed is whether the reward has been redeemed. It is only known for
+	// authenticated requests.
+	Redeemed *bool `json:"redeemed,omitempty"`
+}
+
+// RosterReport is the clan's roster.
+type RosterReport struct {
+	ClanID      int64         `json:"clanId"`
+	MemberCount int           `json:"memberCount"`
+	Members     []*ClanMember `json:"members"`
+	// TriumphScores are the members' triumph scores by membership ID, if
+	// they were got with --profile-components.
+	TriumphScores map[int64]int64 `json:"triumphScores,omitempty"`
+}
+
+func newRosterReport(clanID int64, members []*ClanMember) *RosterReport {
+	return &RosterReport{
+		ClanID:      clanID,
+		MemberCount: len(members),
+		Members:     members,
+	}
+}
+
+// CompletionReport is the earliest clan completion of an activity mode, or the
+// fastest one when it's the Fastest of another CompletionReport.
+type CompletionReport struct {
+	Mode         string `json:"mode"`
+	ActivityHash int64  `json:"activityHash,omitempty"`
+	// Activity and Tier are the name and difficulty tier of the activity,
+	// if they're known.
+	Activity string    `json:"activity,omitempty"`
+	Tier     string    `json:"tier,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	// DurationSeconds is how long the activity took.
+	DurationSeconds int64    `json:"durationSeconds"`
+	Fireteam        []string `json:"fireteam"`
+	// FireteamMembers are the members of Fireteam, in the same order.
+	FireteamMembers []MemberReport `json:"fireteamMembers"`
+	// Incomplete are the clan members who were in the activity but didn't
+	// complete it, if --show-incomplete was given.
+	Incomplete        []string       `json:"incomplete,omitempty"`
+	IncompleteMembers []MemberReport `json:"incompleteMembers,omitempty"`
+	// Guests are the players in the fireteam who aren't in the clan now, if
+	// --show-guests was given.
+	Guests       []string       `json:"guests,omitempty"`
+	GuestMembers []MemberReport `json:"guestMembers,omitempty"`
+	// Carry is whether the clan members were a minority of the fireteam.
+	Carry bool `json:"carry,omitempty"`
+	// Count is the number of clan completions of the mode, if they were
+	// counted.
+	Count int `json:"count,omitempty"`
+	// Fastest is the clan completion of the mode that took the least time.
+	Fastest *CompletionReport `json:"fastest,omitempty"`
+}
+
+// Label returns the mode of the completion, followed by the tier and name of
+// the activity if they're known (e.g. "Nightfall: Hard – The
+// Corrupted").
+func (c *CompletionReport) Label() string {
+	if c.Activity == "" {
+		return c.Mode
+	}
+	if c.Tier == "" {
+		return fmt.Sprintf("%v: %v", c.Mode, c.Activity)
+	}
+	return fmt.Sprintf("%v: %v – %v", c.Mode, c.Tier, c.Activity)
+}
+
+// AttemptReport is a clan activity that wasn't completed.
+type AttemptReport struct {
+	Mode            string         `json:"mode"`
+	Start           time.Time      `json:"start"`
+	End             time.Time      `json:"end"`
+	Fireteam        []string       `json:"fireteam"`
+	FireteamMembers []MemberReport `json:"fireteamMembers"`
+}
+
+// MemberReport identifies a member by their membership, which unlike their
+// name is a stable key.
+type MemberReport struct {
+	MembershipID   int64  `json:"membershipId"`
+	MembershipType int64  `json:"membershipType"`
+	DisplayName    string `json:"displayName"`
+}
+
+// newMemberReports returns the reports of the users, sorted by name like
+// getSortedNames.
+func newMemberReports(users []*models.UserUserInfoCard) []MemberReport {
+	var reports []MemberReport
+	for _, user := range users {
+		reports = append(reports, MemberReport{
+			MembershipID:   user.MembershipID,
+			MembershipType: user.MembershipType,
+			DisplayName:    getDisplayName(user),
+		})
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].DisplayName < reports[j].DisplayName
+	})
+	return reports
+}
+
+// SummaryReport summarizes the clan completions for a week.
+type SummaryReport struct {
+	ModesCompleted int        `json:"modesCompleted"`
+	ModesTracked   int        `json:"modesTracked"`
+	Earliest       *time.Time `json:"earliest,omitempty"`
+	// SampledMembers is the number of clan members that were scanned, when
+	// --max-members scanned only a sample of the ClanMembers.
+	SampledMembers int `json:"sampledMembers,omitempty"`
+	ClanMembers    int `json:"clanMembers,omitempty"`
+	// PartialRoster is whether the clan roster was cut short, so that only
+	// the ClanMembers that were fetched were scanned.
+	PartialRoster bool `json:"partialRoster,omitempty"`
+	// Interrupted is whether the scan was interrupted, so that the
+	// completions are only those found before then.
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+func newCompletionReport(m trackedMode, c *completion) *CompletionReport {
+	return &CompletionReport{
+		Mode:              m.name,
+		ActivityHash:      c.activityHash,
+		Start:             c.start,
+		End:               c.end,
+		DurationSeconds:   int64(c.duration / time.Second),
+		Fireteam:          c.getFireteamNames(),
+		FireteamMembers:   newMemberReports(c.fireteamMembers),
+		Incomplete:        c.getIncompleteNames(),
+		IncompleteMembers: newMemberReports(c.incompleteMembers),
+		Guests:            c.getGuestNames(),
+		GuestMembers:      newMemberReports(c.guests),
+		Carry:             c.isCarry(),
+	}
+}
+
+func newWeekReport(clanID int64, start, end time.Time, results map[ActivityMode]*modeResult) *WeekReport {
+	report := &WeekReport{
+		ClanID: clanID,
+		Start:  start,
+		End:    end,
+	}
+	if now := time.Now(); !now.Before(start) && now.Before(end) {
+		report.Current = true
+		report.RemainingSeconds = int64(end.Sub(now).Truncate(time.Hour) / time.Second)
+	}
+	var earliest *completion
+	for _, m := range modes {
+		c := results[m.mode].earliest
+		if c == nil {
+			continue
+		}
+		completionReport := newCompletionReport(m, c)
+		if flagCountAll {
+			completionReport.Count = results[m.mode].count
+		}
+		if fastest := results[m.mode].fastest; fastest != nil {
+			completionReport.Fastest = newCompletionReport(m, fastest)
+		}
+		report.Completions = append(report.Completions, completionReport)
+		earliest = reduceEarliest(earliest, c)
+	}
+	for _, m := range modes {
+		for _, c := range results[m.mode].attempts {
+			report.Attempts = append(report.Attempts, &AttemptReport{
+				Mode:            m.name,
+				Start:           c.start,
+				End:             c.end,
+				Fireteam:        c.getFireteamNames(),
+				FireteamMembers: newMemberReports(c.fireteamMembers),
+			})
+		}
+	}
+	report.Summary.ModesCompleted = len(report.Completions)
+	report.Summary.ModesTracked = len(modes)
+	if earliest != nil {
+		report.Summary.Earliest = &earliest.end
+	}
+	return report
+}
+
+// newRewardCategoryReport returns the report of the reward category, with the
+// progress of each entry from its objective in objectives.
+func newRewardCategoryReport(reward *models.DestinyMilestonesDestinyMilestoneRewardCategory, milestoneDefinition *models.DestinyDefinitionsMilestonesDestinyMilestoneDefinition, objectives map[int64]*models.DestinyQuestsDestinyObjectiveProgress, includeRedeemed bool) RewardCategoryReport {
+	rewardCategoryHashStr := strconv.FormatUint(uint64(reward.RewardCategoryHash), 10)
+	rewardCategory := milestoneDefinition.Rewards[rewardCategoryHashStr]
+	report := RewardCategoryReport{
+		Name: rewardCategory.DisplayProperties.Name,
+	}
+	for _, entry := range reward.Entries {
+		rewardEntryHashStr := strconv.FormatUint(uint64(entry.RewardEntryHash), 10)
+		entryReport := RewardEntryReport{
+			Name:   rewardCategory.RewardEntries[rewardEntryHashStr].DisplayProperties.Name,
+			Earned: entry.Earned,
+		}
+		entryReport.Progress, entryReport.CompletionValue = getRewardEntryProgress(entry, objectives[entry.RewardEntryHash])
+		entryReport.ProgressPercent = 100 * float64(entryReport.Progress) / float64(entryReport.CompletionValue)
+		if includeRedeemed {
+			redeemed := entry.Redeemed
+			entryReport.Redeemed = &redeemed
+		}
+		report.Total++
+		if entry.Earned {
+			report.Earned++
+		}
+		report.allEntries = append(report.allEntries, entryReport)
+		if (flagOnlyEarned && !entry.Earned) || (flagOnlyUnearned && entry.Earned) {
+			continue
+		}
+		report.Entries = append(report.Entries, entryReport)
+	}
+	if report.Total > 0 {
+		report.Percent = 100 * float64(report.Earned) / float64(report.Total)
+	}
+	return report
+}
+
+// ContributorsReport are the clan members who were in the most fireteams of
+// the earliest clan completions.
+type ContributorsReport struct {
+	ClanID       int64                `json:"clanId"`
+	Contributors []*ContributorReport `json:"contributors"`
+	// Consolidated is set for the --consolidate leaderboard of all of the
+	// contributors, rather than the --top contributors.
+	Consolidated bool `json:"consolidated,omitempty"`
+}
+
+// ContributorReport is a clan member and the number of fireteams of the
+// earliest clan completions that they were in.
+type ContributorReport struct {
+	MembershipID   int64  `json:"membershipId"`
+	MembershipType int64  `json:"membershipType"`
+	Name           string `json:"name"`
+	Count          int    `json:"count"`
+	// Modes are the counts of each mode, by the mode's name.
+	Modes map[string]int `json:"modes"`
+}
+
+// addContributions counts the members of the fireteams of the earliest clan
+// completions.
+func addContributions(contributions map[int64]*ContributorReport, results map[ActivityMode]*modeResult) {
+	for _, m := range modes {
+		result := results[m.mode]
+		if result == nil || result.earliest == nil {
+			continue
+		}
+		for _, fireteamMember := range result.earliest.fireteamMembers {
+			contributor, ok := contributions[fireteamMember.MembershipID]
+			if !ok {
+				contributor = &ContributorReport{
+					MembershipID:   fireteamMember.MembershipID,
+					MembershipType: fireteamMember.MembershipType,
+					Name:           getDisplayName(fireteamMember),
+					Modes:          make(map[string]int),
+				}
+				contributions[fireteamMember.MembershipID] = contributor
+			}
+			contributor.Count++
+			contributor.Modes[m.name]++
+		}
+	}
+}
+
+type byContribution []*ContributorReport
+
+func (b byContribution) Len() int      { return len(b) }
+func (b byContribution) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byContribution) Less(i, j int) bool {
+	if b[i].Count != b[j].Count {
+		return b[i].Count > b[j].Count
+	}
+	return strings.ToLower(b[i].Name) < strings.ToLower(b[j].Name)
+}
+
+// newContributorsReport returns the top n contributors.
+func newContributorsReport(clanID int64, contributions map[int64]*ContributorReport, n int) *ContributorsReport {
+	report := &ContributorsReport{ClanID: clanID}
+	for _, contributor := range contributions {
+		report.Contributors = append(report.Contributors, contributor)
+	}
+	sort.Sort(byContribution(report.Contributors))
+	if len(report.Contributors) > n {
+		report.Contributors = report.Contributors[:n]
+	}
+	return report
+}
+
+// MetaReport is the cost of the run: how much was scanned, the API calls it
+// took and how long it took.
+type MetaReport struct {
+	MembersScanned  int     `json:"membersScanned"`
+	APICalls        int     `json:"apiCalls"`
+	Retries         int     `json:"retries"`
+	Throttles       int     `json:"throttles"`
+	CacheHits       int     `json:"cacheHits"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+func newMetaReport() *MetaReport {
+	report := stats.newMetaReport()
+	report.MembersScanned, report.DurationSeconds = summary.get()
+	return report
+}
+
+// String returns the report as a single line.
+func (m *MetaReport) String() string {
+	return fmt.Sprintf("%v members scanned, %v API calls, %v retries, %v throttles, %v cache hits in %v", m.MembersScanned, m.APICalls, m.Retries, m.Throttles, m.CacheHits, time.Duration(m.DurationSeconds*float64(time.Second)).Round(time.Millisecond))
+}
+
+// reportWriter writes week reports in some output format.
+type reportWriter interface {
+	WriteRoster(report *RosterReport) error
+	WriteWeek(report *WeekReport) error
+	WriteContributors(report *ContributorsReport) error
+	// WriteMeta writes the cost of the run, if the format includes it.
+	WriteMeta(report *MetaReport) error
+	// Flush writes anything that is held until all of the reports have been
+	// written.
+	Flush() error
+}
+
+// newReportWriter returns a reportWriter for the format. Times in the text
+// and html formats are shown in loc using the layout, or time.Time's default
+// layout if layout is empty. The text and html formats use the template in
+// the templatePath file, or their built-in template if it's empty. The text
+// format instead writes all of the reports at once with outputTemplate if it
+// isn't empty. The output is redacted with --redact.
+func newReportWriter(format string, w io.Writer, loc *time.Location, layout, templatePath, outputTemplate string) (reportWriter, error) {
+	if redaction == nil {
+		return newFormatReportWriter(format, w, loc, layout, templatePath, outputTemplate)
+	}
+	rw := &redactingWriter{w: w}
+	out, err := newFormatReportWriter(format, rw, loc, layout, templatePath, outputTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return &redactingReportWriter{out, rw}, nil
+}
+
+func newFormatReportWriter(format string, w io.Writer, loc *time.Location, layout, templatePath, outputTemplate string) (reportWriter, error) {
+	if templatePath != "" && outputTemplate != "" {
+		return nil, errors.Errorf("--template and --output-template are mutually exclusive")
+	}
+	switch format {
+	case "text":
+		if outputTemplate != "" {
+			return newOutputTemplateReportWriter(w, loc, layout, outputTemplate)
+		}
+		return newTextReportWriter(w, loc, layout, templatePath)
+	case "ndjson", "jsonl":
+		return &ndjsonReportWriter{json.NewEncoder(w)}, nil
+	case "html":
+		return newHTMLReportWriter(w, loc, layout, templatePath)
+	default:
+		return nil, errors.Errorf("unknown output format %q", format)
+	}
+}
+
+// flushReport writes the cost of the run, unless --no-footer is given, and
+// flushes out.
+func flushReport(out reportWriter) error {
+	if !flagNoFooter {
+		if err := out.WriteMeta(newMetaReport()); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// collectedReport is all of the reports, for the templates that write them at
+// once. Roster is the clan's members, Weeks are each week's reward category
+// (Reward), clan completions of each mode (Completions) and summary,
+// Contributors are the top contributors if --top was given, and Leaderboard is
+// all of the contributors if --consolidate was given.
+type collectedReport struct {
+	ClanID       int64
+	Roster       *RosterReport
+	Weeks        []*WeekReport
+	Contributors *ContributorsReport
+	Leaderboard  *ContributorsReport
+}
+
+// collectedReportWriter collects the reports and writes them all at once with
+// a template when it's flushed.
+type collectedReportWriter struct {
+	w    io.Writer
+	tmpl interface {
+		Execute(w io.Writer, data interface{}) error
+	}
+	report collectedReport
+}
+
+func (c *collectedReportWriter) WriteRoster(report *RosterReport) error {
+	c.report.ClanID = report.ClanID
+	c.report.Roster = report
+	return nil
+}
+
+func (c *collectedReportWriter) WriteWeek(report *WeekReport) error {
+	c.report.ClanID = report.ClanID
+	c.report.Weeks = append(c.report.Weeks, report)
+	return nil
+}
+
+func (c *collectedReportWriter) WriteContributors(report *ContributorsReport) error {
+	c.report.ClanID = report.ClanID
+	if report.Consolidated {
+		c.report.Leaderboard = report
+		return nil
+	}
+	c.report.Contributors = report
+	return nil
+}
+
+func (c *collectedReportWriter) WriteMeta(report *MetaReport) error {
+	return nil
+}
+
+func (c *collectedReportWriter) Flush() error {
+	return c.tmpl.Execute(c.w, &c.report)
+}
+
+// defaultTextTemplate is the built-in template for the weeks in the text
+// format.
+const defaultTextTemplate = `{{if .Current}}Current week, ends in {{duration .RemainingSeconds}} ({{time .End}})
+{{else if .Reward.Total}}Past week, ended {{time .End}}
+{{end}}{{if .Reward.Entries}}{{printf "%v (%v/%v, %.0f%%)" .Reward.Name .Reward.Earned .Reward.Total .Reward.Percent}}
+{{else if not .Reward.Total}}{{.Reward.Name}}
+{{end}}{{range .Reward.Entries}} {{if .Earned}}✓{{else}} {{end}} {{.Name}} ({{.Progress}}/{{.CompletionValue}}){{if redeemed .}} (redeemed){{end}}
+{{end}}{{if .NewlyEarned}}Just earned: {{join .NewlyEarned ","}}
+{{end}}{{range .Completions}}{{if .Count}}{{printf "%-12s" (print .Label ":")}} {{.Count}} clan completions, earliest at {{time .End}}{{else}}{{printf "%-11s" .Label}} completed at {{time .End}}{{end}} (duration {{duration .DurationSeconds}}) by {{join .Fireteam ","}}{{if .Incomplete}} (didn't complete: {{join .Incomplete ","}}){{end}}{{if .Guests}} (guests: {{join .Guests ","}}){{end}}{{if .Carry}} (carry){{end}}
+{{end}}{{range .Completions}}{{with .Fastest}}Fastest {{.Mode}}: {{duration .DurationSeconds}} by {{join .Fireteam ","}}{{if .Carry}} (carry){{end}}
+{{end}}{{end}}{{range .Attempts}}{{printf "%-11s" .Mode}} attempted at {{time .Start}} by {{join .Fireteam ","}}
+{{end}}{{if .Complete}}All rewards earned; the completions weren't scanned
+{{else if .Summary.Earliest}}{{.Summary.ModesCompleted}}/{{.Summary.ModesTracked}} modes completed, earliest at {{time .Summary.Earliest.UTC}}{{else}}0/{{.Summary.ModesTracked}} modes completed{{end}}
+{{if .Summary.SampledMembers}}Partial sample: only {{.Summary.SampledMembers}} of {{.Summary.ClanMembers}} clan members were scanned
+{{end}}{{if .Summary.PartialRoster}}Partial roster: only the {{.Summary.ClanMembers}} clan members that were fetched were scanned
+{{end}}{{if .Summary.Interrupted}}(partial, interrupted): only the completions found before the run was interrupted
+{{end}}{{if .JoinedAfterStart}}Joined after the week started: {{join .JoinedAfterStart ","}}
+{{end}}{{if .Platforms}}Platform    Members
+{{range $platform, $members := .Platforms}}{{printf "%-11s" $platform}} {{$members}}
+{{end}}{{end}}
+`
+
+type textReportWriter struct {
+	w      io.Writer
+	loc    *time.Location
+	layout string
+	tmpl   *template.Template
+}
+
+// newTextReportWriter returns a textReportWriter that writes the weeks with
+// the text/template in the file, or the built-in template if path is empty.
+func newTextReportWriter(w io.Writer, loc *time.Location, layout, path string) (*textReportWriter, error) {
+	t := &textReportWriter{w: w, loc: loc, layout: layout}
+	source := defaultTextTemplate
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		source = string(data)
+	}
+	tmpl, err := template.New("text").Funcs(t.funcs()).Parse(source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid template %q", path)
+	}
+	t.tmpl = tmpl
+	return t, nil
+}
+
+// newOutputTemplateReportWriter returns a reportWriter that writes all of the
+// reports at once with the text/template in source.
+func newOutputTemplateReportWriter(w io.Writer, loc *time.Location, layout, source string) (*collectedReportWriter, error) {
+	t := &textReportWriter{w: w, loc: loc, layout: layout}
+	tmpl, err := template.New("output").Funcs(t.funcs()).Parse(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid output template")
+	}
+	return &collectedReportWriter{w: w, tmpl: tmpl}, nil
+}
+
+// funcs are the functions that the text templates can use.
+func (t *textReportWriter) funcs() template.FuncMap {
+	return template.FuncMap{
+		"time":     t.formatTime,
+		"duration": formatDurationSeconds,
+		"join":     strings.Join,
+		"name":     getDisplayName,
+		"redeemed": func(entry RewardEntryReport) bool {
+			return entry.Redeemed != nil && *entry.Redeemed
+		},
+	}
+}
+
+func (t *textReportWriter) formatTime(tm time.Time) string {
+	tm = tm.In(t.loc)
+	if t.layout == "" {
+		return tm.String()
+	}
+	return tm.Format(t.layout)
+}
+
+func (t *textReportWriter) WriteRoster(report *RosterReport) error {
+	return nil
+}
+
+func (t *textReportWriter) WriteWeek(report *WeekReport) error {
+	return t.tmpl.Execute(t.w, report)
+}
+
+func (t *textReportWriter) WriteContributors(report *ContributorsReport) error {
+	if report.Consolidated {
+		return t.writeLeaderboard(report)
+	}
+	fmt.Fprintln(t.w, "Top contributors")
+	for i, contributor := range report.Contributors {
+		fmt.Fprintf(t.w, "%3d. %-20s %v\n", i+1, contributor.Name, contributor.Count)
+	}
+	_, err := fmt.Fprintln(t.w)
+	return err
+}
+
+// writeLeaderboard writes the contributors as a table with a column for each
+// mode.
+func (t *textReportWriter) writeLeaderboard(report *ContributorsReport) error {
+	fmt.Fprintln(t.w, "Leaderboard")
+	fmt.Fprintf(t.w, "     %-20s %5s", "Name", "Total")
+	for _, m := range modes {
+		fmt.Fprintf(t.w, " %11s", m.name)
+	}
+	fmt.Fprintln(t.w)
+	for i, contributor := range report.Contributors {
+		fmt.Fprintf(t.w, "%3d. %-20s %5d", i+1, contributor.Name, contributor.Count)
+		for _, m := range modes {
+			fmt.Fprintf(t.w, " %11d", contributor.Modes[m.name])
+		}
+		fmt.Fprintln(t.w)
+	}
+	_, err := fmt.Fprintln(t.w)
+	return err
+}
+
+func (t *textReportWriter) WriteMeta(report *MetaReport) error {
+	return nil
+}
+
+func (t *textReportWriter) Flush() error {
+	return nil
+}
+
+// formatDurationSeconds formats the number of seconds like formatDuration.
+func formatDurationSeconds(seconds int64) string {
+	return formatDuration(time.Duration(seconds) * time.Second)
+}
+
+// formatDuration formats the duration in hours and minutes, like "1h05m".
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%dh%02dm", d/time.Hour, d%time.Hour/time.Minute)
+}
+
+// ndjsonReportWriter writes one self-describing JSON object per line: one for
+// the roster, then for each week one for each completion followed by one for
+// the week.
+type ndjsonReportWriter struct {
+	enc *json.Encoder
+}
+
+type ndjsonLine struct {
+	Type         string              `json:"type"`
+	ClanID       int64               `json:"clanId"`
+	WeekStart    *time.Time          `json:"weekStart,omitempty"`
+	Roster       *RosterReport       `json:"roster,omitempty"`
+	Contributors *ContributorsReport `json:"contributors,omitempty"`
+	Completion   *CompletionReport   `json:"completion,omitempty"`
+	Week         *WeekReport         `json:"week,omitempty"`
+	Meta         *MetaReport         `json:"meta,omitempty"`
+}
+
+func (n *ndjsonReportWriter) WriteRoster(report *RosterReport) error {
+	return n.enc.Encode(&ndjsonLine{Type: "roster", ClanID: report.ClanID, Roster: report})
+}
+
+func (n *ndjsonReportWriter) WriteWeek(report *WeekReport) error {
+	for _, c := range report.Completions {
+		if err := n.enc.Encode(&ndjsonLine{Type: "completion", ClanID: report.ClanID, WeekStart: &report.Start, Completion: c}); err != nil {
+			return err
+		}
+	}
+	return n.enc.Encode(&ndjsonLine{Type: "week", ClanID: report.ClanID, WeekStart: &report.Start, Week: report})
+}
+
+func (n *ndjsonReportWriter) WriteContributors(report *ContributorsReport) error {
+	return n.enc.Encode(&ndjsonLine{Type: "contributors", ClanID: report.ClanID, Contributors: report})
+}
+
+func (n *ndjsonReportWriter) WriteMeta(report *MetaReport) error {
+	return n.enc.Encode(&ndjsonLine{Type: "meta", Meta: report})
+}
+
+func (n *ndjsonReportWriter) Flush() error {
+	return nil
+}
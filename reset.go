@@ -0,0 +1,124 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/zhirsch/destiny2-api/client"
+	"github.com/zhirsch/destiny2-api/client/destiny2"
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+// weekPeriod is the time between weekly resets. Weeks are computed in UTC so
+// that they don't drift across daylight saving time changes.
+const weekPeriod = 7 * 24 * time.Hour
+
+// defaultResetAnchor is a known weekly reset: Tuesday 17:00 UTC.
+var defaultResetAnchor = time.Date(2017, time.September, 5, 17, 0, 0, 0, time.UTC)
+
+// getWeekStart returns the start of the week that contains t, where weeks start
+// at anchor and every weekPeriod after (or before) it.
+func getWeekStart(anchor, t time.Time) time.Time {
+	n := t.Sub(anchor) / weekPeriod
+	start := anchor.Add(n * weekPeriod)
+	if start.After(t) {
+		start = start.Add(-weekPeriod)
+	}
+	return start
+}
+
+// getWeek returns the start and end of the week that contains t.
+func getWeek(anchor, t time.Time) (time.Time, time.Time) {
+	start := getWeekStart(anchor, t)
+	return start, start.Add(weekPeriod)
+}
+
+// weekBounds are the start and end of a week.
+type weekBounds struct {
+	start, end time.Time
+}
+
+func (w weekBounds) contains(t time.Time) bool {
+	return !t.Before(w.start) && t.Before(w.end)
+}
+
+// getCurrentWeek returns the week that contains now: the clan reward state's
+// week if it does, then the clan rewards milestone's week from the public
+// milestones if there is one and it does, and otherwise the week from the
+// reset anchor. Right after the weekly reset, the reward state can still be
+// for the week before.
+func getCurrentWeek(now, anchor time.Time, rewardWeek weekBounds, milestoneWeek *weekBounds) weekBounds {
+	if rewardWeek.contains(now) {
+		return rewardWeek
+	}
+	if milestoneWeek != nil && milestoneWeek.contains(now) {
+		return *milestoneWeek
+	}
+	start, end := getWeek(anchor, now)
+	return weekBounds{start, end}
+}
+
+// getMilestoneWeek returns the week of the milestone from the public
+// milestones, or nil if it isn't one of them.
+func getMilestoneWeek(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, milestoneHash int64) (*weekBounds, error) {
+	logger.Printf("getting public milestones")
+	params := destiny2.NewDestiny2GetPublicMilestonesParams()
+	start := time.Now()
+	resp, err := api.Destiny2.Destiny2GetPublicMilestones(params, auth)
+	stats.record("get public milestones", start)
+	if err != nil {
+		return nil, err
+	}
+	for _, milestone := range resp.Payload.Response {
+		if milestone.MilestoneHash != milestoneHash {
+			continue
+		}
+		week := weekBounds{time.Time(milestone.StartDate).UTC(), time.Time(milestone.EndDate).UTC()}
+		if week.start.IsZero() || !week.end.After(week.start) {
+			return nil, nil
+		}
+		return &week, nil
+	}
+	return nil, nil
+}
+
+// alignRewardsToReset makes the newest of the rewards' weeks the current one.
+// If the clan reward state is still for the week before the reset, a week
+// with nothing earned yet is added before its weeks. It returns the start and
+// end of the newest week.
+func alignRewardsToReset(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, rewards *models.DestinyMilestonesDestinyMilestone, anchor, now time.Time) (time.Time, time.Time) {
+	rewardWeek := weekBounds{time.Time(rewards.StartDate).UTC(), time.Time(rewards.EndDate).UTC()}
+	if rewardWeek.contains(now) {
+		return rewardWeek.start, rewardWeek.end
+	}
+	milestoneHash := rewards.MilestoneHash
+	if milestoneHash == 0 {
+		milestoneHash = flagMilestoneHash
+	}
+	milestoneWeek, err := getMilestoneWeek(api, auth, milestoneHash)
+	if err != nil {
+		logger.Printf("warning: unable to get the public milestones, using the reset anchor instead: %v", err)
+	}
+	return addCurrentWeek(rewards, rewardWeek, getCurrentWeek(now, anchor, rewardWeek, milestoneWeek))
+}
+
+// addCurrentWeek adds the current week, with nothing earned yet, before the
+// rewards' weeks if the reward state's week is the one before it. It returns
+// the start and end of the newest week.
+func addCurrentWeek(rewards *models.DestinyMilestonesDestinyMilestone, rewardWeek, current weekBounds) (time.Time, time.Time) {
+	if !current.start.Equal(rewardWeek.end) || len(rewards.Rewards) == 0 {
+		logger.Printf("warning: the clan reward state is for %v to %v, which isn't the current week or the one before it", rewardWeek.start, rewardWeek.end)
+		return rewardWeek.start, rewardWeek.end
+	}
+	logger.Printf("the clan reward state is still for the week before the reset; adding the week %v to %v", current.start, current.end)
+	var entries []*models.DestinyMilestonesDestinyMilestoneRewardEntry
+	for _, entry := range rewards.Rewards[0].Entries {
+		entries = append(entries, &models.DestinyMilestonesDestinyMilestoneRewardEntry{RewardEntryHash: entry.RewardEntryHash})
+	}
+	fresh := &models.DestinyMilestonesDestinyMilestoneRewardCategory{
+		RewardCategoryHash: rewards.Rewards[0].RewardCategoryHash,
+		Entries:            entries,
+	}
+	rewards.Rewards = append([]*models.DestinyMilestonesDestinyMilestoneRewardCategory{fresh}, rewards.Rewards...)
+	return current.start, current.end
+}
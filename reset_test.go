@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+func TestGetWeek(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name      string
+		t         time.Time
+		wantStart time.Time
+	}{
+		{"at the anchor", defaultResetAnchor, defaultResetAnchor},
+		{"just before a reset", utc(2020, time.March, 10, 16, 59), utc(2020, time.March, 3, 17, 0)},
+		{"at a reset", utc(2020, time.March, 10, 17, 0), utc(2020, time.March, 10, 17, 0)},
+		{"before the anchor", utc(2017, time.August, 30, 12, 0), utc(2017, time.August, 29, 17, 0)},
+		// Daylight saving time started on March 8, 2020 in New York, so
+		// local times don't move the reset.
+		{"spring forward week", time.Date(2020, time.March, 9, 12, 0, 0, 0, newYork), utc(2020, time.March, 3, 17, 0)},
+		{"after spring forward", time.Date(2020, time.March, 10, 13, 30, 0, 0, newYork), utc(2020, time.March, 10, 17, 0)},
+		// It ended on November 1, 2020.
+		{"fall back week", time.Date(2020, time.November, 1, 1, 30, 0, 0, newYork), utc(2020, time.October, 27, 17, 0)},
+		{"after fall back", time.Date(2020, time.November, 3, 12, 0, 0, 0, newYork), utc(2020, time.November, 3, 17, 0)},
+	}
+	for _, tt := range tests {
+		start, end := getWeek(defaultResetAnchor, tt.t)
+		if !start.Equal(tt.wantStart) {
+			t.Errorf("%v: getWeek(%v) starts %v; want %v", tt.name, tt.t, start, tt.wantStart)
+		}
+		if d := end.Sub(start); d != weekPeriod {
+			t.Errorf("%v: getWeek(%v) is %v long; want %v", tt.name, tt.t, d, weekPeriod)
+		}
+		if start.Weekday() != time.Tuesday || start.Hour() != 17 || start.Location() != time.UTC {
+			t.Errorf("%v: getWeek(%v) starts %v; want a Tuesday 17:00 UTC", tt.name, tt.t, start)
+		}
+	}
+}
+
+func TestGetCurrentWeek(t *testing.T) {
+	reset := time.Date(2020, time.March, 10, 17, 0, 0, 0, time.UTC)
+	lastWeek := weekBounds{reset.Add(-weekPeriod), reset}
+	thisWeek := weekBounds{reset, reset.Add(weekPeriod)}
+	// The anchor is off by an hour, so that falling back to it shows.
+	anchor := defaultResetAnchor.Add(time.Hour)
+	anchorWeek := weekBounds{reset.Add(time.Hour - weekPeriod), reset.Add(time.Hour)}
+	tests := []struct {
+		name          string
+		now           time.Time
+		rewardWeek    weekBounds
+		milestoneWeek *weekBounds
+		want          weekBounds
+	}{
+		{"before the reset", reset.Add(-time.Second), lastWeek, nil, lastWeek},
+		{"at the reset", reset, thisWeek, nil, thisWeek},
+		{"stale at the reset", reset, lastWeek, &thisWeek, thisWeek},
+		{"stale after the reset", reset.Add(time.Minute), lastWeek, &thisWeek, thisWeek},
+		{"stale milestone", reset.Add(time.Minute), lastWeek, &lastWeek, anchorWeek},
+		{"no milestone", reset.Add(-time.Minute), weekBounds{}, nil, anchorWeek},
+	}
+	for _, tt := range tests {
+		got := getCurrentWeek(tt.now, anchor, tt.rewardWeek, tt.milestoneWeek)
+		if !got.start.Equal(tt.want.start) || !got.end.Equal(tt.want.end) {
+			t.Errorf("%v: getCurrentWeek() = %v to %v; want %v to %v", tt.name, got.start, got.end, tt.want.start, tt.want.end)
+		}
+	}
+}
+
+func TestAddCurrentWeek(t *testing.T) {
+	reset := time.Date(2020, time.March, 10, 17, 0, 0, 0, time.UTC)
+	lastWeek := weekBounds{reset.Add(-weekPeriod), reset}
+	thisWeek := weekBounds{reset, reset.Add(weekPeriod)}
+	nextWeek := weekBounds{reset.Add(weekPeriod), reset.Add(2 * weekPeriod)}
+	newRewards := func() *models.DestinyMilestonesDestinyMilestone {
+		return &models.DestinyMilestonesDestinyMilestone{
+			Rewards: []*models.DestinyMilestonesDestinyMilestoneRewardCategory{{
+				RewardCategoryHash: 1,
+				Entries: []*models.DestinyMilestonesDestinyMilestoneRewardEntry{
+					{RewardEntryHash: 10, Earned: true, Redeemed: true},
+					{RewardEntryHash: 20},
+				},
+			}},
+		}
+	}
+	tests := []struct {
+		name       string
+		rewards    *models.DestinyMilestonesDestinyMilestone
+		rewardWeek weekBounds
+		current    weekBounds
+		want       weekBounds
+		wantAdded  bool
+	}{
+		{"week before", newRewards(), lastWeek, thisWeek, thisWeek, true},
+		{"two weeks before", newRewards(), lastWeek, nextWeek, lastWeek, false},
+		{"week after", newRewards(), thisWeek, lastWeek, thisWeek, false},
+		{"no rewards", &models.DestinyMilestonesDestinyMilestone{}, lastWeek, thisWeek, lastWeek, false},
+	}
+	for _, tt := range tests {
+		categories := len(tt.rewards.Rewards)
+		start, end := addCurrentWeek(tt.rewards, tt.rewardWeek, tt.current)
+		if !start.Equal(tt.want.start) || !end.Equal(tt.want.end) {
+			t.Errorf("%v: addCurrentWeek() = %v to %v; want %v to %v", tt.name, start, end, tt.want.start, tt.want.end)
+		}
+		if added := len(tt.rewards.Rewards) > categories; added != tt.wantAdded {
+			t.Errorf("%v: added a week = %v; want %v", tt.name, added, tt.wantAdded)
+			continue
+		}
+		if !tt.wantAdded {
+			continue
+		}
+		fresh, stale := tt.rewards.Rewards[0], tt.rewards.Rewards[1]
+		if fresh.RewardCategoryHash != stale.RewardCategoryHash || len(fresh.Entries) != len(stale.Entries) {
+			t.Errorf("%v: the added week isn't like the stale one", tt.name)
+			continue
+		}
+		for i, entry := range fresh.Entries {
+			if entry.RewardEntryHash != stale.Entries[i].RewardEntryHash || entry.Earned || entry.Redeemed {
+				t.Errorf("%v: added entry %+v; want %v with nothing earned", tt.name, entry, stale.Entries[i].RewardEntryHash)
+			}
+		}
+		if !stale.Entries[0].Earned {
+			t.Errorf("%v: the stale week's earned entry was reset", tt.name)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+)
+
+// jsonSchema is a JSON Schema, or the part of one that describes a type.
+type jsonSchema map[string]interface{}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	dateTimeType   = reflect.TypeOf(strfmt.DateTime{})
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	reportLineType = reflect.TypeOf(ndjsonLine{})
+)
+
+// schemaGenerator generates the schema of a type from its JSON encoding. Named
+// struct types are defined once in defs and referred to, which allows for
+// recursive types.
+type schemaGenerator struct {
+	defs map[string]jsonSchema
+}
+
+// schema returns the schema of the type. Nil pointers, slices and maps are
+// encoded as null, so their schemas allow it.
+func (g *schemaGenerator) schema(t reflect.Type) jsonSchema {
+	if t == timeType || t == dateTimeType {
+		return jsonSchema{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema{"anyOf": []jsonSchema{g.schema(t.Elem()), {"type": "null"}}}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Slice:
+		return jsonSchema{"type": []string{"array", "null"}, "items": g.schema(t.Elem())}
+	case reflect.Array:
+		return jsonSchema{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": []string{"object", "null"}, "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+			return jsonSchema{}
+		}
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			// Reserve the name first so that a recursive reference doesn't
+			// define it again.
+			g.defs[t.Name()] = nil
+			g.defs[t.Name()] = g.object(t)
+		}
+		return jsonSchema{"$ref": "#/$defs/" + t.Name()}
+	default:
+		return jsonSchema{}
+	}
+}
+
+// object returns the schema of the struct's fields, as encoding/json encodes
+// them.
+func (g *schemaGenerator) object(t reflect.Type) jsonSchema {
+	properties := make(map[string]jsonSchema)
+	var required []string
+	g.addFields(t, properties, &required)
+	s := jsonSchema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]jsonSchema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, options = tag[:i], tag[i+1:]
+		}
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(ft, properties, required)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// writeReportSchema writes the JSON Schema of the lines of --format=ndjson.
+func writeReportSchema(w io.Writer) error {
+	g := &schemaGenerator{defs: make(map[string]jsonSchema)}
+	s := g.schema(reportLineType)
+	s = jsonSchema{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "destinyclanrewards report line",
+		"$ref":    s["$ref"],
+		"$defs":   g.defs,
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
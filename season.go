@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/pkg/errors"
+	"github.com/zhirsch/destiny2-api/client"
+	"github.com/zhirsch/destiny2-api/client/destiny2"
+	"github.com/zhirsch/destiny2-api/models"
+	db "github.com/zhirsch/destiny2-db"
+)
+
+// getSeason returns the definition of a season, which is either "current" or a
+// season number. The seasons are found from the user's profile and resolved
+// using the manifest.
+func getSeason(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, manifest *db.DB, user *models.UserUserInfoCard, season string) (*models.DestinyDefinitionsSeasonsDestinySeasonDefinition, error) {
+	logger.Printf("getting seasons for destiny user %v (%q)", user.MembershipID, user.DisplayName)
+	params := destiny2.NewDestiny2GetProfileParams()
+	params.SetDestinyMembershipID(user.MembershipID)
+	params.SetMembershipType(int32(user.MembershipType))
+	params.SetComponents([]int64{100})
+	start := time.Now()
+	resp, err := api.Destiny2.Destiny2GetProfile(params, auth)
+	stats.record("get profile", start)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Payload.Response == nil || resp.Payload.Response.Profile == nil || resp.Payload.Response.Profile.Data == nil {
+		return nil, errors.Errorf("no profile for destiny user %v (%q)", user.MembershipID, user.DisplayName)
+	}
+	profile := resp.Payload.Response.Profile.Data
+
+	if season == "current" {
+		return getSeasonDefinition(manifest, profile.CurrentSeasonHash)
+	}
+	number, err := strconv.Atoi(season)
+	if err != nil {
+		return nil, errors.Errorf("invalid season %q", season)
+	}
+	for _, seasonHash := range profile.SeasonHashes {
+		seasonDefinition, err := getSeasonDefinition(manifest, seasonHash)
+		if err != nil {
+			return nil, err
+		}
+		if int(seasonDefinition.SeasonNumber) == number {
+			return seasonDefinition, nil
+		}
+	}
+	return nil, errors.Errorf("unknown season %v", number)
+}
+
+func getSeasonDefinition(manifest *db.DB, seasonHash int64) (*models.DestinyDefinitionsSeasonsDestinySeasonDefinition, error) {
+	seasonDefinitionInterface, err := getDefinition(manifest, "DestinySeasonDefinition", uint32(seasonHash), &models.DestinyDefinitionsSeasonsDestinySeasonDefinition{})
+	if err != nil {
+		return nil, err
+	}
+	return seasonDefinitionInterface.(*models.DestinyDefinitionsSeasonsDestinySeasonDefinition), nil
+}
+
+// getSeasonReport returns the clan completions for the whole season, up to
+// weekEnd, the end of the current reward week. Stopping at the week's end
+// rather than now keeps the scan's checkpoint key the same for the week, so
+// that an interrupted scan can be resumed.
+func getSeasonReport(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, clanID int64, season *models.DestinyDefinitionsSeasonsDestinySeasonDefinition, clanMembers, scanMembers []*ClanMember, weekEnd time.Time) (*WeekReport, error) {
+	start, end := time.Time(season.StartDate), time.Time(season.EndDate)
+	if end.After(weekEnd) {
+		end = weekEnd
+	}
+	results, err := getEarliestClanCompletions(api, auth, clanID, start, end, clanMembers, scanMembers, nil)
+	if err != nil && errors.Cause(err) != errInterrupted {
+		return nil, err
+	}
+	report := newWeekReport(clanID, start, end, results)
+	report.Reward.Name = season.DisplayProperties.Name
+	// An interrupted scan's report is returned with the results so far.
+	return report, err
+}
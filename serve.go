@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// runDurationBuckets are the upper bounds, in seconds, of the run duration
+// histogram buckets.
+var runDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800}
+
+// runHistogram is a histogram of how long the reports served by /report took.
+type runHistogram struct {
+	mu      sync.Mutex
+	buckets []int
+	count   int
+	sum     float64
+}
+
+func newRunHistogram() *runHistogram {
+	return &runHistogram{buckets: make([]int, len(runDurationBuckets))}
+}
+
+func (h *runHistogram) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, le := range runDurationBuckets {
+		if d.Seconds() <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += d.Seconds()
+}
+
+// Write writes the histogram in the Prometheus text format.
+func (h *runHistogram) Write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP run_duration_seconds How long each report took.\n")
+	fmt.Fprintf(w, "# TYPE run_duration_seconds histogram\n")
+	for i, le := range runDurationBuckets {
+		fmt.Fprintf(w, "run_duration_seconds_bucket{le=\"%v\"} %d\n", le, h.buckets[i])
+	}
+	fmt.Fprintf(w, "run_duration_seconds_bucket{le=\"+Inf\"} %d\n", h.count)
+	fmt.Fprintf(w, "run_duration_seconds_sum %v\n", h.sum)
+	fmt.Fprintf(w, "run_duration_seconds_count %d\n", h.count)
+}
+
+// lastSuccesses records when each clan's report last succeeded.
+type lastSuccesses struct {
+	mu   sync.Mutex
+	last map[int64]time.Time
+}
+
+var clanSuccesses = &lastSuccesses{last: make(map[int64]time.Time)}
+
+func (s *lastSuccesses) record(clanID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last[clanID] = time.Now()
+}
+
+// Write writes the times in the Prometheus text format.
+func (s *lastSuccesses) Write(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var clanIDs []int64
+	for clanID := range s.last {
+		clanIDs = append(clanIDs, clanID)
+	}
+	sort.Slice(clanIDs, func(i, j int) bool { return clanIDs[i] < clanIDs[j] })
+	fmt.Fprintf(w, "# HELP clan_last_success_timestamp_seconds When the clan's report last succeeded.\n")
+	fmt.Fprintf(w, "# TYPE clan_last_success_timestamp_seconds gauge\n")
+	for _, clanID := range clanIDs {
+		fmt.Fprintf(w, "clan_last_success_timestamp_seconds{clan=\"%d\"} %d\n", clanID, s.last[clanID].Unix())
+	}
+}
+
+// WriteMetrics writes the API call counts and latencies in the Prometheus
+// text format.
+func (s *apiStats) WriteMetrics(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var endpoints []string
+	for endpoint := range s.calls {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	fmt.Fprintf(w, "# HELP api_requests_total The number of Bungie API requests.\n")
+	fmt.Fprintf(w, "# TYPE api_requests_total counter\n")
+	for _, endpoint := range endpoints {
+		fmt.Fprintf(w, "api_requests_total{endpoint=%q} %d\n", endpoint, s.calls[endpoint])
+	}
+	fmt.Fprintf(w, "# HELP api_request_seconds_total How long the Bungie API requests took.\n")
+	fmt.Fprintf(w, "# TYPE api_request_seconds_total counter\n")
+	for _, endpoint := range endpoints {
+		fmt.Fprintf(w, "api_request_seconds_total{endpoint=%q} %v\n", endpoint, s.latency[endpoint].Seconds())
+	}
+	fmt.Fprintf(w, "# HELP pgcr_cache_hits_total The number of PGCRs found in the cache.\n")
+	fmt.Fprintf(w, "# TYPE pgcr_cache_hits_total counter\n")
+	fmt.Fprintf(w, "pgcr_cache_hits_total %d\n", s.cacheHits)
+	fmt.Fprintf(w, "# HELP pgcr_cache_misses_total The number of PGCRs not found in the cache.\n")
+	fmt.Fprintf(w, "# TYPE pgcr_cache_misses_total counter\n")
+	fmt.Fprintf(w, "pgcr_cache_misses_total %d\n", s.cacheMiss)
+	fmt.Fprintf(w, "# HELP api_throttles_total The number of Bungie API requests that were throttled.\n")
+	fmt.Fprintf(w, "# TYPE api_throttles_total counter\n")
+	fmt.Fprintf(w, "api_throttles_total %d\n", s.throttles)
+	fmt.Fprintf(w, "# HELP api_retries_total The number of throttled requests that were retried with another API key.\n")
+	fmt.Fprintf(w, "# TYPE api_retries_total counter\n")
+	fmt.Fprintf(w, "api_retries_total %d\n", s.retries)
+}
+
+// serve serves /metrics and /report on addr. /report responds with the report
+// as NDJSON (application/x-ndjson), the same lines that --format=ndjson writes
+// and --print-schema describes, so that a client can stream the weeks as they
+// arrive. Reports are run one at a time, since they share the global state set
+// up from the flags.
+func serve(addr string) error {
+	runs := newRunHistogram()
+	var mu sync.Mutex
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		stats.WriteMetrics(w)
+		runs.Write(w)
+		clanSuccesses.Write(w)
+	})
+	http.HandleFunc("/report", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		start := time.Now()
+		// The report is buffered so that a failed one is only an error
+		// rather than some of the report followed by an error.
+		var buf bytes.Buffer
+		err := writeReport(&buf, "ndjson")
+		runs.observe(time.Since(start))
+		if errors.Cause(err) == errMaintenance {
+			// The API will be back, so the server keeps running.
+			logger.Printf("report failed: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			logger.Printf("report failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write(buf.Bytes())
+	})
+	logger.Printf("serving on %v", addr)
+	return http.ListenAndServe(addr, nil)
+}
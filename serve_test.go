@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	s := &apiStats{
+		calls:   make(map[string]int),
+		latency: make(map[string]time.Duration),
+	}
+	s.record("pgcr", time.Now())
+	s.record("pgcr", time.Now())
+	s.recordThrottle(true)
+	s.recordThrottle(false)
+	var buf bytes.Buffer
+	s.WriteMetrics(&buf)
+	tests := []string{
+		`api_requests_total{endpoint="pgcr"} 2`,
+		"api_throttles_total 2",
+		"api_retries_total 1",
+		"# TYPE api_retries_total counter",
+	}
+	lines := strings.Split(buf.String(), "\n")
+	for _, want := range tests {
+		found := false
+		for _, line := range lines {
+			if line == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("WriteMetrics() has no line %q:\n%v", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zhirsch/destiny2-api/models"
+)
+
+// exitCodePartial is the exit code with --fail-on-partial when some clan
+// members were skipped.
+const exitCodePartial = 4
+
+// skippedMembers are the clan members, or single characters of them, whose
+// activities couldn't be scanned, which makes the report partial.
+type skippedMembers struct {
+	mu      sync.Mutex
+	reasons map[string]string
+}
+
+var skipped = newSkippedMembers()
+
+func newSkippedMembers() *skippedMembers {
+	return &skippedMembers{reasons: make(map[string]string)}
+}
+
+func (s *skippedMembers) add(user *models.UserUserInfoCard, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reasons[fmt.Sprintf("%v (%v)", getDisplayName(user), user.MembershipID)] = reason
+}
+
+// addCharacter records that one of the member's characters was skipped.
+func (s *skippedMembers) addCharacter(user *models.UserUserInfoCard, characterID int64, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reasons[fmt.Sprintf("%v (%v) character %v", getDisplayName(user), user.MembershipID, characterID)] = reason
+}
+
+// merge adds the members skipped in other.
+func (s *skippedMembers) merge(other *skippedMembers) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for member, reason := range other.reasons {
+		s.reasons[member] = reason
+	}
+}
+
+func (s *skippedMembers) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.reasons)
+}
+
+// Write writes a warning that lists the skipped members.
+func (s *skippedMembers) Write(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var members []string
+	for member, reason := range s.reasons {
+		members = append(members, fmt.Sprintf("%v: %v", member, reason))
+	}
+	sort.Strings(members)
+	fmt.Fprintf(w, "warning: the report is partial; %d members or characters were skipped:\n  %v\n", len(members), strings.Join(members, "\n  "))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// incrementalOverlap is how long before the last run an incremental scan
+// starts, so that activities that were in progress during the last run aren't
+// missed. The activities that were already evaluated are skipped.
+const incrementalOverlap = 6 * time.Hour
+
+// stateFile is the state kept in the --state-file between runs: whether each
+// reward entry was earned, so that newly earned rewards can be reported, and
+// the results of each clan's scans, so that the next run only scans the
+// activities since the last run.
+type stateFile struct {
+	mu sync.Mutex
+	// Rewards are whether each reward entry was earned, by the start of its
+	// week and then by the entry's name.
+	Rewards map[string]map[string]bool `json:"rewards"`
+	Clans   map[int64]*clanState       `json:"clans"`
+}
+
+// clanState is the state of a clan's scans.
+type clanState struct {
+	// LastRun is when the last successful run started.
+	LastRun time.Time `json:"lastRun"`
+	// Settings are the result settings of the runs that the weeks were
+	// scanned by, which only a run with the same settings resumes.
+	Settings string `json:"settings,omitempty"`
+	// Weeks are the results of the scan of each week, by the start of the
+	// week.
+	Weeks map[string]map[ActivityMode]*modeResult `json:"weeks"`
+}
+
+func newStateFile() *stateFile {
+	return &stateFile{
+		Rewards: make(map[string]map[string]bool),
+		Clans:   make(map[int64]*clanState),
+	}
+}
+
+// loadStateFile reads the state file, which need not exist. If path is empty,
+// the state isn't kept and nil is returned.
+func loadStateFile(path string) (*stateFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	s := newStateFile()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// save writes the state to the file. The state isn't written if there's no
+// file, as with --watch.
+func (s *stateFile) save(path string) error {
+	if s == nil || path == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// getResultSettings returns the settings that change which clan members are
+// scanned, which completions are counted for the clan, or what is collected
+// about them, so that the results of a run with some settings aren't used by a
+// run with others.
+func getResultSettings() string {
+	return fmt.Sprintf("members-file=%v,include-members=%v,exclude-members=%v,max-members=%v,count-all=%v,count-present=%v,clan-majority=%v,no-fireteam-check=%v,include-incomplete=%v,show-incomplete=%v,show-guests=%v",
+		flagMembersFile, flagIncludeMembers, flagExcludeMembers, flagMaxMembers, flagCountAll, flagCountPresent, flagClanMajority, flagNoFireteamCheck, flagIncludeIncomplete, flagShowIncomplete, flagShowGuests)
+}
+
+func getWeekKey(start time.Time) string {
+	return start.UTC().Format(time.RFC3339)
+}
+
+// updateRewards records the week's reward state, and returns the entries that
+// are earned now but weren't the last time the week was seen.
+func (s *stateFile) updateRewards(report *WeekReport) []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := getWeekKey(report.Start)
+	previous, seen := s.Rewards[key]
+	current := make(map[string]bool)
+	var newlyEarned []string
+	// All of the entries are compared, so that the ones filtered out of the
+	// report aren't forgotten and then reported as newly earned.
+	for _, entry := range report.Reward.allEntries {
+		current[entry.Name] = entry.Earned
+		if seen && entry.Earned && !previous[entry.Name] {
+			newlyEarned = append(newlyEarned, entry.Name)
+		}
+	}
+	s.Rewards[key] = current
+	return newlyEarned
+}
+
+// resume returns when the scan of the clan's week needs to start from and the
+// results to start it with. If the week was scanned by the last run with the
+// same result settings, only the activities since then need to be scanned, and
+// if the week had already ended then, the returned start is the end of the
+// week. Otherwise the whole week is scanned from scratch.
+func (s *stateFile) resume(clanID int64, start, end time.Time) (time.Time, map[ActivityMode]*modeResult) {
+	if s == nil {
+		return start, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clan, ok := s.Clans[clanID]
+	if !ok || clan.Settings != getResultSettings() {
+		return start, nil
+	}
+	results, ok := clan.Weeks[getWeekKey(start)]
+	if !ok {
+		return start, nil
+	}
+	// The modes may have changed since the last run.
+	for _, m := range modes {
+		if results[m.mode] == nil {
+			return start, nil
+		}
+	}
+	if !clan.LastRun.Before(end) {
+		return end, results
+	}
+	if resumeAt := clan.LastRun.Add(-incrementalOverlap); resumeAt.After(start) {
+		return resumeAt, results
+	}
+	return start, results
+}
+
+// record records the results of the scan of the clan's week.
+func (s *stateFile) record(clanID int64, start time.Time, results map[ActivityMode]*modeResult) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clan, ok := s.Clans[clanID]
+	if !ok {
+		clan = &clanState{}
+		s.Clans[clanID] = clan
+	}
+	// The weeks scanned with other settings can't be resumed by this run
+	// or the next.
+	if settings := getResultSettings(); clan.Weeks == nil || clan.Settings != settings {
+		clan.Weeks = make(map[string]map[ActivityMode]*modeResult)
+		clan.Settings = settings
+	}
+	clan.Weeks[getWeekKey(start)] = results
+}
+
+// finish records that the run that started at lastRun was successful. Only the
+// weeks that were recorded by the run are kept, so that old weeks are dropped.
+func (s *stateFile) finish(clanID int64, lastRun time.Time, weekStarts []time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clan, ok := s.Clans[clanID]
+	if !ok {
+		clan = &clanState{}
+		s.Clans[clanID] = clan
+	}
+	clan.LastRun = lastRun
+	weeks := make(map[string]map[ActivityMode]*modeResult)
+	for _, start := range weekStarts {
+		key := getWeekKey(start)
+		if results, ok := clan.Weeks[key]; ok {
+			weeks[key] = results
+		}
+	}
+	clan.Weeks = weeks
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestGetResultSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func()
+	}{
+		{"members file", func() { flagMembersFile = "members.txt" }},
+		{"include members", func() { flagIncludeMembers = "1" }},
+		{"exclude members", func() { flagExcludeMembers = "1" }},
+		{"max members", func() { flagMaxMembers = 5 }},
+		{"count all", func() { flagCountAll = true }},
+		{"count present", func() { flagCountPresent = true }},
+		{"clan majority", func() { flagClanMajority = true }},
+		{"no fireteam check", func() { flagNoFireteamCheck = true }},
+		{"include incomplete", func() { flagIncludeIncomplete = true }},
+		{"show incomplete", func() { flagShowIncomplete = true }},
+		{"show guests", func() { flagShowGuests = true }},
+	}
+	reset := func() {
+		flagMembersFile, flagIncludeMembers, flagExcludeMembers, flagMaxMembers = "", "", "", 0
+		flagCountAll, flagCountPresent, flagClanMajority, flagNoFireteamCheck = false, false, false, false
+		flagIncludeIncomplete, flagShowIncomplete, flagShowGuests = false, false, false
+	}
+	defer func(membersFile, includeMembers, excludeMembers string, maxMembers int, countAll, countPresent, clanMajority, noFireteamCheck, includeIncomplete, showIncomplete, showGuests bool) {
+		flagMembersFile, flagIncludeMembers, flagExcludeMembers, flagMaxMembers = membersFile, includeMembers, excludeMembers, maxMembers
+		flagCountAll, flagCountPresent, flagClanMajority, flagNoFireteamCheck = countAll, countPresent, clanMajority, noFireteamCheck
+		flagIncludeIncomplete, flagShowIncomplete, flagShowGuests = includeIncomplete, showIncomplete, showGuests
+	}(flagMembersFile, flagIncludeMembers, flagExcludeMembers, flagMaxMembers, flagCountAll, flagCountPresent, flagClanMajority, flagNoFireteamCheck, flagIncludeIncomplete, flagShowIncomplete, flagShowGuests)
+	reset()
+	defaults := getResultSettings()
+	for _, tt := range tests {
+		reset()
+		tt.set()
+		if got := getResultSettings(); got == defaults {
+			t.Errorf("%v: getResultSettings() = %q, the same as the defaults", tt.name, got)
+		}
+	}
+}
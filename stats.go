@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// apiStats records the number of calls to each API endpoint and how long they
+// took, and how often the PGCR cache was hit.
+type apiStats struct {
+	mu        sync.Mutex
+	calls     map[string]int
+	latency   map[string]time.Duration
+	cacheHits int
+	cacheMiss int
+	// throttles are the calls that were throttled, and retries are those
+	// that were then retried.
+	throttles int
+	retries   int
+}
+
+var stats = &apiStats{
+	calls:   make(map[string]int),
+	latency: make(map[string]time.Duration),
+}
+
+// record records a call to the endpoint that started at start. It's called
+// right after the call returns, whether or not it failed.
+func (s *apiStats) record(endpoint string, start time.Time) {
+	d := time.Since(start)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[endpoint]++
+	s.latency[endpoint] += d
+}
+
+// recordCache records whether a PGCR was found in the cache.
+func (s *apiStats) recordCache(hit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hit {
+		s.cacheHits++
+	} else {
+		s.cacheMiss++
+	}
+}
+
+// recordThrottle records that a call was throttled, and whether it was
+// retried.
+func (s *apiStats) recordThrottle(retried bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttles++
+	if retried {
+		s.retries++
+	}
+}
+
+// newMetaReport returns a MetaReport with the API calls filled in.
+func (s *apiStats) newMetaReport() *MetaReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := &MetaReport{
+		Retries:   s.retries,
+		Throttles: s.throttles,
+		CacheHits: s.cacheHits,
+	}
+	for _, calls := range s.calls {
+		report.APICalls += calls
+	}
+	return report
+}
+
+// total returns the number of calls to all of the endpoints.
+func (s *apiStats) total() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total int
+	for _, calls := range s.calls {
+		total += calls
+	}
+	return total
+}
+
+// Write writes a table of the calls to each endpoint, slowest first.
+func (s *apiStats) Write(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var endpoints []string
+	for endpoint := range s.calls {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return s.latency[endpoints[i]] > s.latency[endpoints[j]]
+	})
+	fmt.Fprintf(w, "%-20s %8s %12s %12s\n", "endpoint", "calls", "total", "average")
+	for _, endpoint := range endpoints {
+		calls, latency := s.calls[endpoint], s.latency[endpoint]
+		average := latency / time.Duration(calls)
+		fmt.Fprintf(w, "%-20s %8d %12v %12v\n", endpoint, calls, latency.Round(time.Millisecond), average.Round(time.Millisecond))
+	}
+	if lookups := s.cacheHits + s.cacheMiss; lookups > 0 {
+		fmt.Fprintf(w, "pgcr cache: %d/%d hits (%d%%)\n", s.cacheHits, lookups, s.cacheHits*100/lookups)
+	}
+}
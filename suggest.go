@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/zhirsch/destiny2-api/client"
+	"github.com/zhirsch/destiny2-api/client/user"
+)
+
+// maxSuggestions is the most close matches suggested for a username that
+// isn't found.
+const maxSuggestions = 5
+
+// minSuggestionPrefix is the shortest prefix that is searched for suggestions,
+// so that a short one doesn't match most of the players.
+const minSuggestionPrefix = 3
+
+// suggestDestinyUsers returns the names of the players that the username might
+// have meant, closest first. The search only matches whole names, so the
+// broader search is for the Bungie Names starting with the name without its
+// code (e.g. "Name" for "Name#1234"), which finds the players with the same
+// name and other codes and the longer names. If that finds none, as when the
+// name has a typo, shorter prefixes of it are searched.
+func suggestDestinyUsers(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, username string) []string {
+	name := username
+	if i := strings.LastIndex(name, "#"); i >= 0 {
+		name = name[:i]
+	}
+	prefix := []rune(strings.TrimSpace(name))
+	var names []string
+	for n := len(prefix); n > 0 && len(names) == 0; n /= 2 {
+		if n < minSuggestionPrefix && n < len(prefix) {
+			break
+		}
+		var err error
+		names, err = searchBungieNamePrefix(api, auth, string(prefix[:n]))
+		if err != nil {
+			memberLogger.Printf("unable to search for players named %q: %v", string(prefix[:n]), err)
+			return nil
+		}
+	}
+	target := strings.ToLower(username)
+	sort.SliceStable(names, func(i, j int) bool {
+		return editDistance(strings.ToLower(names[i]), target) < editDistance(strings.ToLower(names[j]), target)
+	})
+	if len(names) > maxSuggestions {
+		names = names[:maxSuggestions]
+	}
+	return names
+}
+
+// searchBungieNamePrefix returns the Bungie Names that start with the prefix.
+func searchBungieNamePrefix(api *client.BungieNet, auth runtime.ClientAuthInfoWriter, prefix string) ([]string, error) {
+	params := user.NewUserSearchByGlobalNamePrefixParams()
+	params.SetDisplayNamePrefix(prefix)
+	params.SetPage(0)
+	start := time.Now()
+	resp, err := api.User.UserSearchByGlobalNamePrefix(params, auth)
+	stats.record("search name prefix", start)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Payload.Response == nil {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, result := range resp.Payload.Response.SearchResults {
+		n := fmt.Sprintf("%v#%04d", result.BungieGlobalDisplayName, result.BungieGlobalDisplayNameCode)
+		if !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	return names, nil
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// runSummary is the record of a run written to stderr with --summary-json, for
+// log scraping and alerting.
+type runSummary struct {
+	mu               sync.Mutex
+	start            time.Time
+	clans            int
+	membersScanned   int
+	allRewardsEarned *bool
+}
+
+var summary = newRunSummary()
+
+func newRunSummary() *runSummary {
+	return &runSummary{start: time.Now()}
+}
+
+// addClan records that a clan's members were scanned.
+func (s *runSummary) addClan(membersScanned int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clans++
+	s.membersScanned += membersScanned
+}
+
+// get returns the number of members scanned and how long the run has taken.
+func (s *runSummary) get() (int, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.membersScanned, time.Since(s.start).Seconds()
+}
+
+// setRewardsEarned records whether all of the current week's rewards have been
+// earned.
+func (s *runSummary) setRewardsEarned(earned bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allRewardsEarned = &earned
+}
+
+type runSummaryJSON struct {
+	Clans            int     `json:"clans"`
+	MembersScanned   int     `json:"membersScanned"`
+	MembersSkipped   int     `json:"membersSkipped"`
+	APICalls         int     `json:"apiCalls"`
+	DurationSeconds  float64 `json:"durationSeconds"`
+	AllRewardsEarned *bool   `json:"allRewardsEarned,omitempty"`
+	Error            string  `json:"error,omitempty"`
+}
+
+// Write writes the summary as a single line of JSON. err is the error the run
+// failed with, if any.
+func (s *runSummary) Write(w io.Writer, err error) error {
+	s.mu.Lock()
+	v := &runSummaryJSON{
+		Clans:            s.clans,
+		MembersScanned:   s.membersScanned,
+		MembersSkipped:   skipped.len(),
+		APICalls:         stats.total(),
+		DurationSeconds:  time.Since(s.start).Seconds(),
+		AllRewardsEarned: s.allRewardsEarned,
+	}
+	s.mu.Unlock()
+	if err != nil {
+		v.Error = err.Error()
+	}
+	return json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// watchState keeps the reward state and scan results between the runs of
+// --watch when there's no --state-file, so that each run only scans the
+// activities since the last one.
+var watchState *stateFile
+
+// watch writes the report every interval, but only when it has changed since
+// the last time it was written, until interrupted. An interrupt during a run
+// stops its scan, and its partial report is written before errInterrupted is
+// returned.
+func watch(interval time.Duration) error {
+	if interval <= 0 {
+		return errors.Errorf("invalid interval %v", interval)
+	}
+	if flagStateFile == "" {
+		watchState = newStateFile()
+	}
+	stopHandlingInterrupts := handleInterrupts()
+	defer stopHandlingInterrupts()
+	var last []byte
+	for {
+		var buf bytes.Buffer
+		err := writeReport(&buf, flagFormat)
+		if errors.Cause(err) == errInterrupted {
+			if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			return err
+		}
+		if errors.Cause(err) == errMaintenance {
+			// The API will be back, so try again at the next interval.
+			fmt.Fprintln(os.Stderr, err)
+		} else if err != nil {
+			return err
+		} else if !bytes.Equal(buf.Bytes(), last) {
+			if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			last = buf.Bytes()
+		} else {
+			logger.Printf("the report hasn't changed")
+		}
+		if skipped.len() > 0 {
+			skipped.Write(redactWriter(os.Stderr))
+		}
+		select {
+		case <-interrupted:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}